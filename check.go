@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/flaambe/authservice/config"
+	"github.com/flaambe/authservice/mailer"
+	"github.com/flaambe/authservice/tlsconf"
+)
+
+// checkConfig prints the configuration with secrets redacted, then validates
+// it and that the database is reachable, printing every problem found, and
+// exits non-zero if there is any.
+func checkConfig(args []string) {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+
+	cfg, err := config.Load(flags, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := loadSecrets(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Print(cfg.Redacted())
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if dir := cfg.Mail.TemplatesDir; dir != "" {
+		if _, err := mailer.LoadTemplates(dir); err != nil {
+			fmt.Fprintln(os.Stderr, "mail.templates_dir:", err)
+			os.Exit(1)
+		}
+	}
+
+	if opts := cfg.TLSOptions(); opts != nil {
+		if _, err := tlsconf.NewServer(*opts); err != nil {
+			fmt.Fprintln(os.Stderr, "tls:", err)
+			os.Exit(1)
+		}
+	}
+
+	dbConfig, err := connectDB(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	closeDB(dbConfig)
+
+	fmt.Println("configuration OK")
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/flaambe/authservice/views"
+)
+
+func (c *Client) Register(ctx context.Context, username, email, password string) (views.RegisterResponse, error) {
+	var response views.RegisterResponse
+
+	request := views.RegisterRequest{Username: username, Email: email, Password: password}
+	err := c.do(ctx, http.MethodPost, "/register", "", request, &response)
+
+	return response, err
+}
+
+// Login returns either the token pair or, when the user has a second factor
+// enabled, the MFA challenge to complete with LoginMFA.
+func (c *Client) Login(ctx context.Context, login, password string) (views.LoginResponse, error) {
+	var response views.LoginResponse
+
+	err := c.do(ctx, http.MethodPost, "/login", "", views.LoginRequest{Login: login, Password: password}, &response)
+
+	return response, err
+}
+
+func (c *Client) ChangePassword(ctx context.Context, accessToken, oldPassword, newPassword string) error {
+	request := views.ChangePasswordRequest{OldPassword: oldPassword, NewPassword: newPassword}
+
+	return c.do(ctx, http.MethodPost, "/changePassword", accessToken, request, nil)
+}
+
+func (c *Client) RequestEmailLogin(ctx context.Context, email string) error {
+	return c.do(ctx, http.MethodPost, "/login/email", "", views.EmailLoginRequest{Email: email}, nil)
+}
+
+// RedeemEmailLogin completes an email login with either the link token or
+// the email address and one-time code.
+func (c *Client) RedeemEmailLogin(ctx context.Context, token, email, code string) (views.LoginResponse, error) {
+	var response views.LoginResponse
+
+	request := views.EmailLoginRedeemRequest{Token: token, Email: email, Code: code}
+	err := c.do(ctx, http.MethodPost, "/login/email/redeem", "", request, &response)
+
+	return response, err
+}
+
+func (c *Client) ForgotPassword(ctx context.Context, email string) error {
+	return c.do(ctx, http.MethodPost, "/password/forgot", "", views.ForgotPasswordRequest{Email: email}, nil)
+}
+
+func (c *Client) ResetPassword(ctx context.Context, token, newPassword string) error {
+	request := views.ResetPasswordRequest{Token: token, NewPassword: newPassword}
+
+	return c.do(ctx, http.MethodPost, "/password/reset", "", request, nil)
+}
+
+func (c *Client) RequestEmailVerification(ctx context.Context, accessToken string) error {
+	return c.do(ctx, http.MethodPost, "/email/verify/request", accessToken, nil, nil)
+}
+
+func (c *Client) VerifyEmail(ctx context.Context, token string) error {
+	return c.do(ctx, http.MethodPost, "/email/verify", "", views.VerifyEmailRequest{Token: token}, nil)
+}
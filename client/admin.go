@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/flaambe/authservice/views"
+)
+
+// CreateUser creates a user for guid, or for a generated GUID when guid is
+// empty. Admin calls take the ADMIN_TOKEN of the service.
+func (c *Client) CreateUser(ctx context.Context, adminToken, guid string) (views.UserResponse, error) {
+	var response views.UserResponse
+
+	err := c.do(ctx, http.MethodPost, "/admin/users", adminToken, views.CreateUserRequest{GUID: guid}, &response)
+
+	return response, err
+}
+
+func (c *Client) DisableUser(ctx context.Context, adminToken, guid string) (views.UserResponse, error) {
+	var response views.UserResponse
+
+	err := c.do(ctx, http.MethodPost, "/admin/users/"+url.PathEscape(guid)+"/disable", adminToken, nil, &response)
+
+	return response, err
+}
+
+func (c *Client) EnableUser(ctx context.Context, adminToken, guid string) (views.UserResponse, error) {
+	var response views.UserResponse
+
+	err := c.do(ctx, http.MethodPost, "/admin/users/"+url.PathEscape(guid)+"/enable", adminToken, nil, &response)
+
+	return response, err
+}
+
+func (c *Client) SetUserGrants(ctx context.Context, adminToken, guid string, roles, scopes []string) (views.UserResponse, error) {
+	var response views.UserResponse
+
+	request := views.UserGrantsRequest{Roles: roles, Scopes: scopes}
+	err := c.do(ctx, http.MethodPut, "/admin/users/"+url.PathEscape(guid)+"/grants", adminToken, request, &response)
+
+	return response, err
+}
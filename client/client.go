@@ -0,0 +1,227 @@
+// Package client is a Go client for the authservice HTTP API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flaambe/authservice/views"
+)
+
+const bearerSchema = "Bearer "
+
+// Errors matched by errors.Is against the Error returned for a response
+// status.
+var (
+	ErrBadRequest      = errors.New("bad request")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrForbidden       = errors.New("forbidden")
+	ErrNotFound        = errors.New("not found")
+	ErrConflict        = errors.New("conflict")
+	ErrTooManyRequests = errors.New("too many requests")
+)
+
+// Error is returned for responses with an error status.
+type Error struct {
+	StatusCode int
+	// Message is the error message of the views.ErrorResponse body.
+	Message string
+	// RetryAfter is set from the Retry-After header of throttled responses.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("authservice: %d %s", e.StatusCode, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return target == ErrBadRequest
+	case http.StatusUnauthorized:
+		return target == ErrUnauthorized
+	case http.StatusForbidden:
+		return target == ErrForbidden
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusConflict:
+		return target == ErrConflict
+	case http.StatusTooManyRequests:
+		return target == ErrTooManyRequests
+	default:
+		return false
+	}
+}
+
+// Client calls the authservice API at a base URL such as
+// "http://localhost:8080". It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	userAgent  string
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests instead of
+// http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithUserAgent sets the User-Agent header, which names the sessions created
+// by the client.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+func (c *Client) Auth(ctx context.Context, guid string) (views.AuthResponse, error) {
+	var response views.AuthResponse
+
+	err := c.do(ctx, http.MethodPost, "/auth", "", views.AuthRequest{GUID: guid}, &response)
+
+	return response, err
+}
+
+func (c *Client) RefreshToken(ctx context.Context, accessToken, refreshToken string) (views.RefreshResponse, error) {
+	var response views.RefreshResponse
+
+	err := c.do(ctx, http.MethodPost, "/refreshToken", accessToken, views.RefreshTokenRequest{RefreshToken: refreshToken}, &response)
+
+	return response, err
+}
+
+func (c *Client) DeleteToken(ctx context.Context, accessToken, refreshToken string) error {
+	return c.do(ctx, http.MethodPost, "/deleteToken", accessToken, views.DeleteTokenRequest{RefreshToken: refreshToken}, nil)
+}
+
+func (c *Client) DeleteAllTokens(ctx context.Context, accessToken string) error {
+	return c.do(ctx, http.MethodPost, "/deleteAllTokens", accessToken, nil, nil)
+}
+
+// Verify calls the forward-auth endpoint, requiring all of roles and scopes.
+func (c *Client) Verify(ctx context.Context, accessToken string, roles, scopes []string) (views.VerifyResponse, error) {
+	var response views.VerifyResponse
+
+	query := url.Values{}
+	if len(roles) > 0 {
+		query.Set("roles", strings.Join(roles, ","))
+	}
+
+	if len(scopes) > 0 {
+		query.Set("scopes", strings.Join(scopes, ","))
+	}
+
+	path := "/verify"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	err := c.do(ctx, http.MethodGet, path, accessToken, nil, &response)
+
+	return response, err
+}
+
+// Revocations returns the revoked access tokens after cursor since, or a full
+// snapshot when since is zero.
+func (c *Client) Revocations(ctx context.Context, since int64) (views.RevocationFeedResponse, error) {
+	var response views.RevocationFeedResponse
+
+	path := "/revocations"
+	if since > 0 {
+		path += "?since=" + strconv.FormatInt(since, 10)
+	}
+
+	err := c.do(ctx, http.MethodGet, path, "", nil, &response)
+
+	return response, err
+}
+
+// do sends body as JSON and decodes a successful response into out when it
+// is not nil. bearer is sent in the Authorization header when set.
+func (c *Client) do(ctx context.Context, method, path, bearer string, body, out interface{}) error {
+	var reqBody io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if bearer != "" {
+		req.Header.Set("Authorization", bearerSchema+bearer)
+	}
+
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return decodeError(resp)
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func decodeError(resp *http.Response) error {
+	apiErr := &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+
+	var errorResponse views.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errorResponse); err == nil && errorResponse.ErrorMessage != "" {
+		apiErr.Message = errorResponse.ErrorMessage
+	}
+
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	return apiErr
+}
@@ -0,0 +1,97 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flaambe/authservice/client"
+	"github.com/flaambe/authservice/views"
+	"github.com/stretchr/testify/require"
+)
+
+func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func TestErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		respondWithJSON(w, http.StatusTooManyRequests, views.ErrorResponse{ErrorMessage: "too many attempts"})
+	}))
+	defer srv.Close()
+
+	_, err := client.New(srv.URL).Login(context.Background(), "alice", "secret")
+	require.True(t, errors.Is(err, client.ErrTooManyRequests))
+
+	var apiErr *client.Error
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, "too many attempts", apiErr.Message)
+	require.Equal(t, 30*time.Second, apiErr.RetryAfter)
+}
+
+func TestTokenSource(t *testing.T) {
+	var refreshes int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/refreshToken", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&refreshes, 1)
+
+		var body views.RefreshTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken != "refresh-0" {
+			respondWithJSON(w, http.StatusForbidden, views.ErrorResponse{ErrorMessage: "access forbidden"})
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, views.RefreshResponse{
+			AccessToken:  "access-" + string(rune('0'+n)),
+			TokenType:    "Bearer",
+			ExpiresIn:    600,
+			RefreshToken: "refresh-" + string(rune('0'+n)),
+		})
+	})
+	mux.HandleFunc("/resource", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// The access token expires within the refresh margin
+	source := client.NewTokenSource(client.New(srv.URL), views.AuthResponse{
+		AccessToken:  "access-0",
+		ExpiresIn:    10,
+		RefreshToken: "refresh-0",
+	})
+
+	httpClient := &http.Client{Transport: &client.Transport{Source: source}}
+
+	errc := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			resp, err := httpClient.Get(srv.URL + "/resource")
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			errc <- err
+		}()
+	}
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, <-errc)
+	}
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&refreshes))
+
+	accessToken, err := source.Token(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "access-1", accessToken)
+}
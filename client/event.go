@@ -0,0 +1,68 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/flaambe/authservice/views"
+)
+
+// SubscribeEvents reads the security event stream, calling handle for every
+// event after lastEventID, until ctx is done, the stream ends or handle
+// fails. Callers reconnect with the ID of the last handled event.
+func (c *Client) SubscribeEvents(ctx context.Context, eventsToken string, lastEventID int64, handle func(views.SecurityEventResponse) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/events", nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Authorization", bearerSchema+eventsToken)
+
+	if lastEventID > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatInt(lastEventID, 10))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return decodeError(resp)
+	}
+
+	var data strings.Builder
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		case line == "" && data.Len() > 0:
+			var event views.SecurityEventResponse
+			if err := json.Unmarshal([]byte(data.String()), &event); err != nil {
+				return err
+			}
+
+			data.Reset()
+
+			if err := handle(event); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/flaambe/authservice/views"
+)
+
+// LoginMFA completes a login challenge with either a TOTP code or a recovery
+// code.
+func (c *Client) LoginMFA(ctx context.Context, mfaToken, code, recoveryCode string) (views.AuthResponse, error) {
+	var response views.AuthResponse
+
+	request := views.MFALoginRequest{MFAToken: mfaToken, Code: code, RecoveryCode: recoveryCode}
+	err := c.do(ctx, http.MethodPost, "/login/mfa", "", request, &response)
+
+	return response, err
+}
+
+func (c *Client) EnrollTOTP(ctx context.Context, accessToken string) (views.TOTPEnrollResponse, error) {
+	var response views.TOTPEnrollResponse
+
+	err := c.do(ctx, http.MethodPost, "/mfa/totp/enroll", accessToken, nil, &response)
+
+	return response, err
+}
+
+func (c *Client) ConfirmTOTP(ctx context.Context, accessToken, code string) (views.TOTPConfirmResponse, error) {
+	var response views.TOTPConfirmResponse
+
+	err := c.do(ctx, http.MethodPost, "/mfa/totp/confirm", accessToken, views.TOTPConfirmRequest{Code: code}, &response)
+
+	return response, err
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/flaambe/authservice/views"
+)
+
+func (c *Client) BeginPasskeyRegistration(ctx context.Context, accessToken string) (views.PasskeyCreationResponse, error) {
+	var response views.PasskeyCreationResponse
+
+	err := c.do(ctx, http.MethodPost, "/passkeys/register/begin", accessToken, nil, &response)
+
+	return response, err
+}
+
+func (c *Client) FinishPasskeyRegistration(ctx context.Context, accessToken string, request views.PasskeyFinishRequest) error {
+	return c.do(ctx, http.MethodPost, "/passkeys/register/finish", accessToken, request, nil)
+}
+
+func (c *Client) BeginPasskeyLogin(ctx context.Context) (views.PasskeyRequestResponse, error) {
+	var response views.PasskeyRequestResponse
+
+	err := c.do(ctx, http.MethodPost, "/passkeys/login/begin", "", nil, &response)
+
+	return response, err
+}
+
+func (c *Client) FinishPasskeyLogin(ctx context.Context, request views.PasskeyFinishRequest) (views.AuthResponse, error) {
+	var response views.AuthResponse
+
+	err := c.do(ctx, http.MethodPost, "/passkeys/login/finish", "", request, &response)
+
+	return response, err
+}
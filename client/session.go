@@ -0,0 +1,21 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/flaambe/authservice/views"
+)
+
+func (c *Client) ListSessions(ctx context.Context, accessToken string) ([]views.SessionResponse, error) {
+	var response []views.SessionResponse
+
+	err := c.do(ctx, http.MethodGet, "/sessions", accessToken, nil, &response)
+
+	return response, err
+}
+
+func (c *Client) RevokeSession(ctx context.Context, accessToken, sessionID string) error {
+	return c.do(ctx, http.MethodDelete, "/sessions/"+url.PathEscape(sessionID), accessToken, nil, nil)
+}
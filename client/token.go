@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/flaambe/authservice/views"
+)
+
+// DefaultRefreshBefore is how long before expiry a TokenSource refreshes the
+// access token.
+const DefaultRefreshBefore = 30 * time.Second
+
+// TokenSource caches the token pair of a session and refreshes it before the
+// access token expires. It is safe for concurrent use.
+type TokenSource struct {
+	client        *Client
+	refreshBefore time.Duration
+
+	mu           sync.Mutex
+	accessToken  string
+	refreshToken string
+	expiry       time.Time
+}
+
+// NewTokenSource starts from the token pair returned by a login call.
+func NewTokenSource(c *Client, tokens views.AuthResponse) *TokenSource {
+	s := &TokenSource{client: c, refreshBefore: DefaultRefreshBefore}
+	s.set(tokens.AccessToken, tokens.RefreshToken, tokens.ExpiresIn)
+
+	return s
+}
+
+// SetRefreshBefore changes how long before expiry the access token is
+// refreshed.
+func (s *TokenSource) SetRefreshBefore(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refreshBefore = d
+}
+
+// Token returns a valid access token, refreshing the token pair first when
+// the access token is about to expire.
+func (s *TokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Until(s.expiry) > s.refreshBefore {
+		return s.accessToken, nil
+	}
+
+	if err := s.refresh(ctx); err != nil {
+		return "", err
+	}
+
+	return s.accessToken, nil
+}
+
+// Refresh replaces the token pair regardless of its expiry.
+func (s *TokenSource) Refresh(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.refresh(ctx)
+}
+
+// Logout revokes the session of the token source.
+func (s *TokenSource) Logout(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.client.DeleteToken(ctx, s.accessToken, s.refreshToken)
+}
+
+func (s *TokenSource) refresh(ctx context.Context) error {
+	tokens, err := s.client.RefreshToken(ctx, s.accessToken, s.refreshToken)
+	if err != nil {
+		return err
+	}
+
+	s.set(tokens.AccessToken, tokens.RefreshToken, tokens.ExpiresIn)
+
+	return nil
+}
+
+func (s *TokenSource) set(accessToken, refreshToken string, expiresIn int) {
+	s.accessToken = accessToken
+	s.refreshToken = refreshToken
+	s.expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+}
+
+// Transport is an http.RoundTripper that sends the access token of Source as
+// a bearer token.
+type Transport struct {
+	Source *TokenSource
+	// Base is the underlying round tripper, http.DefaultTransport when nil.
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	accessToken, err := t.Source.Token(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", bearerSchema+accessToken)
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return base.RoundTrip(req)
+}
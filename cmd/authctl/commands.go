@@ -0,0 +1,323 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/flaambe/authservice/token"
+	"github.com/flaambe/authservice/views"
+)
+
+const defaultKeyBytes = 64
+
+type keyResponse struct {
+	KeyID  string `json:"kid"`
+	Secret string `json:"secret,omitempty"`
+	File   string `json:"file,omitempty"`
+}
+
+func createUser(c *ctl, args []string) error {
+	flags := flag.NewFlagSet("users create", flag.ContinueOnError)
+	guid := flags.String("guid", "", "")
+	if _, err := parseFlags(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	authUsecase, err := c.usecase()
+	if err != nil {
+		return err
+	}
+
+	user, err := authUsecase.CreateUser(*guid)
+	if err != nil {
+		return err
+	}
+
+	return c.printUsers(user, []views.UserResponse{user})
+}
+
+func getUser(c *ctl, args []string) error {
+	return c.userCommand(args, func(guid string) (views.UserResponse, error) {
+		return c.authUsecase.GetUser(guid)
+	})
+}
+
+func disableUser(c *ctl, args []string) error {
+	return c.userCommand(args, func(guid string) (views.UserResponse, error) {
+		return c.authUsecase.SetUserDisabled(guid, true)
+	})
+}
+
+func enableUser(c *ctl, args []string) error {
+	return c.userCommand(args, func(guid string) (views.UserResponse, error) {
+		return c.authUsecase.SetUserDisabled(guid, false)
+	})
+}
+
+// userCommand runs fn with the single GUID argument and prints the user.
+func (c *ctl) userCommand(args []string, fn func(guid string) (views.UserResponse, error)) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	if _, err := c.usecase(); err != nil {
+		return err
+	}
+
+	user, err := fn(args[0])
+	if err != nil {
+		return err
+	}
+
+	return c.printUsers(user, []views.UserResponse{user})
+}
+
+func listUsers(c *ctl, args []string) error {
+	flags := flag.NewFlagSet("users list", flag.ContinueOnError)
+	query := flags.String("q", "", "")
+	disabledOnly := flags.Bool("disabled", false, "")
+	limit := flags.Int64("limit", 0, "")
+	offset := flags.Int64("offset", 0, "")
+	if _, err := parseFlags(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	authUsecase, err := c.usecase()
+	if err != nil {
+		return err
+	}
+
+	req := views.UserSearchRequest{Query: *query, Limit: *limit, Offset: *offset}
+	if *disabledOnly {
+		req.Disabled = disabledOnly
+	}
+
+	list, err := authUsecase.SearchUsers(req)
+	if err != nil {
+		return err
+	}
+
+	return c.printUsers(list, list.Users)
+}
+
+func (c *ctl) printUsers(v interface{}, users []views.UserResponse) error {
+	return c.print(v, func(w io.Writer) {
+		fmt.Fprintln(w, "GUID\tUSERNAME\tEMAIL\tDISABLED\tROLES\tSCOPES")
+		for _, u := range users {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\n", u.GUID, u.Username, u.Email, u.Disabled,
+				strings.Join(u.Roles, ","), strings.Join(u.Scopes, ","))
+		}
+	})
+}
+
+func listSessions(c *ctl, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	authUsecase, err := c.usecase()
+	if err != nil {
+		return err
+	}
+
+	sessions, err := authUsecase.ListUserSessions(args[0])
+	if err != nil {
+		return err
+	}
+
+	return c.print(sessions, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tNAME\tCLIENT IP\tCREATED\tLAST REFRESHED\tEXPIRES")
+		for _, s := range sessions {
+			lastRefreshed := "-"
+			if s.LastRefreshedAt != nil {
+				lastRefreshed = s.LastRefreshedAt.Format(time.RFC3339)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", s.ID, s.Name, s.ClientIP,
+				s.CreatedAt.Format(time.RFC3339), lastRefreshed, s.ExpiresAt.Format(time.RFC3339))
+		}
+	})
+}
+
+func revokeSessions(c *ctl, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+
+	authUsecase, err := c.usecase()
+	if err != nil {
+		return err
+	}
+
+	result := struct {
+		GUID      string `json:"guid"`
+		SessionID string `json:"session_id,omitempty"`
+	}{GUID: args[0]}
+
+	if len(args) == 2 {
+		result.SessionID = args[1]
+		err = authUsecase.RevokeUserSession(result.GUID, result.SessionID)
+	} else {
+		err = authUsecase.LogoutUser(result.GUID)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return c.print(result, func(w io.Writer) {
+		if result.SessionID != "" {
+			fmt.Fprintf(w, "revoked session %s of user %s\n", result.SessionID, result.GUID)
+		} else {
+			fmt.Fprintf(w, "revoked all sessions of user %s\n", result.GUID)
+		}
+	})
+}
+
+func generateKey(c *ctl, args []string) error {
+	flags := flag.NewFlagSet("keys generate", flag.ContinueOnError)
+	size := flags.Int("bytes", defaultKeyBytes, "")
+	if _, err := parseFlags(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	key, err := newKey(*size)
+	if err != nil {
+		return err
+	}
+
+	return c.print(key, func(w io.Writer) {
+		fmt.Fprintln(w, key.Secret)
+	})
+}
+
+// rotateKey generates a signing secret and writes it to -out when set.
+// Instances using the file secret provider pick up a secret written to their
+// secrets directory and announce the rotation once they sign with it.
+func rotateKey(c *ctl, args []string) error {
+	flags := flag.NewFlagSet("keys rotate", flag.ContinueOnError)
+	size := flags.Int("bytes", defaultKeyBytes, "")
+	out := flags.String("out", "", "")
+	if _, err := parseFlags(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	key, err := newKey(*size)
+	if err != nil {
+		return err
+	}
+
+	if *out != "" {
+		if err := writeSecretFile(*out, key.Secret); err != nil {
+			return err
+		}
+
+		key.Secret = ""
+		key.File = *out
+	}
+
+	return c.print(key, func(w io.Writer) {
+		if key.File != "" {
+			fmt.Fprintf(w, "wrote key %s to %s\n", key.KeyID, key.File)
+		} else {
+			fmt.Fprintf(w, "kid:\t%s\nsecret:\t%s\n", key.KeyID, key.Secret)
+		}
+	})
+}
+
+func purgeTokens(c *ctl, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	authUsecase, err := c.usecase()
+	if err != nil {
+		return err
+	}
+
+	purged, err := authUsecase.PurgeExpired()
+	if err != nil {
+		return err
+	}
+
+	return c.print(purged, func(w io.Writer) {
+		fmt.Fprintf(w, "tokens:\t%d\n", purged.Tokens)
+		fmt.Fprintf(w, "revoked tokens:\t%d\n", purged.RevokedTokens)
+		fmt.Fprintf(w, "one-time codes:\t%d\n", purged.OneTimeCodes)
+		fmt.Fprintf(w, "webauthn challenges:\t%d\n", purged.WebAuthnChallenges)
+	})
+}
+
+func stats(c *ctl, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	authUsecase, err := c.usecase()
+	if err != nil {
+		return err
+	}
+
+	statsResponse, err := authUsecase.Stats()
+	if err != nil {
+		return err
+	}
+
+	return c.print(statsResponse, func(w io.Writer) {
+		fmt.Fprintf(w, "users:\t%d\n", statsResponse.Users)
+		fmt.Fprintf(w, "disabled users:\t%d\n", statsResponse.DisabledUsers)
+		fmt.Fprintf(w, "active sessions:\t%d\n", statsResponse.ActiveSessions)
+		fmt.Fprintf(w, "active access tokens:\t%d\n", statsResponse.ActiveAccessTokens)
+		fmt.Fprintf(w, "revoked tokens:\t%d\n", statsResponse.RevokedTokens)
+	})
+}
+
+// newKey returns a random secret of size bytes, base64 encoded, identified by
+// the kid access tokens signed with it carry.
+func newKey(size int) (keyResponse, error) {
+	if size < 32 {
+		return keyResponse{}, fmt.Errorf("key must be at least 32 bytes")
+	}
+
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return keyResponse{}, err
+	}
+
+	secret := base64.RawURLEncoding.EncodeToString(b)
+
+	return keyResponse{KeyID: token.KeyID([]byte(secret)), Secret: secret}, nil
+}
+
+// writeSecretFile replaces path with secret, readable only by the owner,
+// without leaving a partially written file behind.
+func writeSecretFile(path, secret string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if _, err := tmp.WriteString(secret + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
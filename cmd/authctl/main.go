@@ -0,0 +1,212 @@
+// Command authctl runs operational tasks against the store used by the
+// authentication service. It loads the same configuration as the service.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flaambe/authservice/config"
+	"github.com/flaambe/authservice/mongoconf"
+	"github.com/flaambe/authservice/token"
+	"github.com/flaambe/authservice/usecase"
+)
+
+const usage = `usage: authctl [-json] [-config FILE] [config flags] <command> [arguments]
+
+commands:
+  users create [-guid GUID]          create a user, generating the GUID if omitted
+  users get GUID                     show a user
+  users list [-q QUERY] [-disabled] [-limit N] [-offset N]
+                                     search users by GUID, username or email prefix
+  users disable GUID                 disable a user and revoke its tokens
+  users enable GUID                  re-enable a user
+  sessions list GUID                 list the active sessions of a user
+  sessions revoke GUID [SESSION_ID]  revoke one session, or all sessions of a user
+  keys generate [-bytes N]           print a random signing secret
+  keys rotate [-bytes N] [-out FILE] generate a signing secret for rotation
+  tokens purge                       delete expired tokens, revocations and codes
+  stats                              print user and token statistics
+`
+
+// errUsage is returned by commands called with invalid arguments.
+var errUsage = errors.New("invalid arguments")
+
+type command func(ctl *ctl, args []string) error
+
+var commands = map[string]map[string]command{
+	"users": {
+		"create":  createUser,
+		"get":     getUser,
+		"list":    listUsers,
+		"disable": disableUser,
+		"enable":  enableUser,
+	},
+	"sessions": {
+		"list":   listSessions,
+		"revoke": revokeSessions,
+	},
+	"keys": {
+		"generate": generateKey,
+		"rotate":   rotateKey,
+	},
+	"tokens": {
+		"purge": purgeTokens,
+	},
+	"stats": {
+		"": stats,
+	},
+}
+
+// ctl holds what commands share: the store, opened on first use, and the
+// output format.
+type ctl struct {
+	out    io.Writer
+	json   bool
+	config *config.Config
+
+	dbConfig    *mongoconf.Config
+	authUsecase *usecase.AuthUsecase
+}
+
+func main() {
+	flags := flag.NewFlagSet("authctl", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	jsonOutput := flags.Bool("json", false, "print results as JSON")
+
+	cfg, err := config.Load(flags, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "authctl:", err)
+		os.Exit(2)
+	}
+
+	ctl := &ctl{out: os.Stdout, json: *jsonOutput, config: cfg}
+	defer ctl.close()
+
+	err = ctl.run(flags.Args())
+	if errors.Is(err, errUsage) {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "authctl:", err)
+		ctl.close()
+		os.Exit(1)
+	}
+}
+
+func (c *ctl) run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	subcommands, ok := commands[args[0]]
+	if !ok {
+		return errUsage
+	}
+
+	if cmd, ok := subcommands[""]; ok {
+		return cmd(c, args[1:])
+	}
+
+	if len(args) < 2 {
+		return errUsage
+	}
+
+	cmd, ok := subcommands[args[1]]
+	if !ok {
+		return errUsage
+	}
+
+	return cmd(c, args[2:])
+}
+
+// usecase validates the configuration and connects to the store on first
+// use.
+func (c *ctl) usecase() (*usecase.AuthUsecase, error) {
+	if c.authUsecase != nil {
+		return c.authUsecase, nil
+	}
+
+	if provider := c.config.SecretProvider(); provider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := c.config.LoadSecrets(ctx, provider); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	dbConfig := mongoconf.NewConfig()
+	if err := dbConfig.Open(string(c.config.MongoDB.URI), c.config.MongoDB.Name); err != nil {
+		return nil, err
+	}
+
+	c.dbConfig = dbConfig
+
+	authUsecase, err := usecase.NewAuthUsecase(dbConfig.DB, token.NewSigner(c.config.TokenConfig()))
+	if err != nil {
+		return nil, err
+	}
+
+	c.authUsecase = authUsecase
+
+	return c.authUsecase, nil
+}
+
+func (c *ctl) close() {
+	if c.dbConfig == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c.dbConfig.DB.Client().Disconnect(ctx)
+	c.dbConfig = nil
+}
+
+// print writes v as indented JSON when -json is set, otherwise it calls text
+// with a tab-aligned writer.
+func (c *ctl) print(v interface{}, text func(w io.Writer)) error {
+	if c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	text(tw)
+
+	return tw.Flush()
+}
+
+// parseFlags parses args with flags and checks the number of positional
+// arguments left is between min and max.
+func parseFlags(flags *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	flags.SetOutput(io.Discard)
+
+	if err := flags.Parse(args); err != nil {
+		return nil, errUsage
+	}
+
+	rest := flags.Args()
+	if len(rest) < min || len(rest) > max {
+		return nil, errUsage
+	}
+
+	return rest, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/flaambe/authservice/token"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunUsage(t *testing.T) {
+	c := &ctl{out: &bytes.Buffer{}}
+
+	for _, args := range [][]string{
+		nil,
+		{"unknown"},
+		{"users"},
+		{"users", "unknown"},
+		{"users", "get"},
+		{"sessions", "revoke", "a", "b", "c"},
+		{"keys", "generate", "-unknown"},
+	} {
+		require.True(t, errors.Is(c.run(args), errUsage), "%v", args)
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	var out bytes.Buffer
+	c := &ctl{out: &out, json: true}
+
+	err := c.run([]string{"keys", "generate", "-bytes", "32"})
+	require.NoError(t, err)
+
+	var key keyResponse
+	require.NoError(t, json.Unmarshal(out.Bytes(), &key))
+	require.Len(t, key.Secret, 43)
+	require.Equal(t, token.KeyID([]byte(key.Secret)), key.KeyID)
+
+	err = c.run([]string{"keys", "generate", "-bytes", "16"})
+	require.Error(t, err)
+}
+
+func TestWriteSecretFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.key")
+
+	require.NoError(t, os.WriteFile(path, []byte("old\n"), 0644))
+	require.NoError(t, writeSecretFile(path, "new"))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "new", strings.TrimSpace(string(b)))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
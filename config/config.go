@@ -0,0 +1,388 @@
+// Package config loads the service configuration from a YAML file, the
+// environment and command-line flags.
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flaambe/authservice/password"
+	"github.com/flaambe/authservice/secrets"
+	"github.com/flaambe/authservice/tlsconf"
+	"github.com/flaambe/authservice/token"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MinSecretLength is the minimum length in bytes of the token signing
+// secrets.
+const MinSecretLength = 32
+
+// Secret is a configuration value that is redacted when printed.
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+
+	return "REDACTED"
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// Config is the configuration of the service. Each field can be set in the
+// file under its yaml key, with the environment variable in its env tag, or
+// with a flag named after its dotted yaml path, in increasing precedence.
+type Config struct {
+	Port              string `yaml:"port" env:"PORT"`
+	LogLevel          string `yaml:"log_level" env:"LOG_LEVEL"`
+	TrustProxyHeaders bool   `yaml:"trust_proxy_headers" env:"TRUST_PROXY_HEADERS"`
+	GRPCAddr          string `yaml:"grpc_addr" env:"GRPC_ADDR"`
+	ExtAuthzAddr      string `yaml:"ext_authz_addr" env:"EXT_AUTHZ_ADDR"`
+	EventsToken       Secret `yaml:"events_token" env:"EVENTS_TOKEN" secret:"events_token"`
+
+	MongoDB  MongoDBConfig  `yaml:"mongodb"`
+	Tokens   TokensConfig   `yaml:"tokens"`
+	Admin    AdminConfig    `yaml:"admin"`
+	Auth     AuthConfig     `yaml:"auth"`
+	WebAuthn WebAuthnConfig `yaml:"webauthn"`
+	Mail     MailConfig     `yaml:"mail"`
+	Sessions SessionsConfig `yaml:"sessions"`
+	Argon2   Argon2Config   `yaml:"argon2"`
+	Secrets  SecretsConfig  `yaml:"secrets"`
+	TLS      TLSConfig      `yaml:"tls"`
+}
+
+type MongoDBConfig struct {
+	// URI may hold credentials.
+	URI  Secret `yaml:"uri" env:"MONGODB_URI" secret:"mongodb_uri"`
+	Name string `yaml:"name" env:"DBNAME"`
+}
+
+type TokensConfig struct {
+	AccessSecret  Secret        `yaml:"access_secret" env:"ACCESS_SECRET" secret:"access_secret"`
+	RefreshSecret Secret        `yaml:"refresh_secret" env:"REFRESH_SECRET" secret:"refresh_secret"`
+	AccessTTL     time.Duration `yaml:"access_ttl" env:"ACCESS_TOKEN_TTL"`
+	RefreshTTL    time.Duration `yaml:"refresh_ttl" env:"REFRESH_TOKEN_TTL"`
+	MFATTL        time.Duration `yaml:"mfa_ttl" env:"MFA_TOKEN_TTL"`
+}
+
+type AdminConfig struct {
+	Token Secret `yaml:"token" env:"ADMIN_TOKEN" secret:"admin_token"`
+	Role  string `yaml:"role" env:"ADMIN_ROLE"`
+	Addr  string `yaml:"addr" env:"ADMIN_ADDR"`
+}
+
+type AuthConfig struct {
+	GUIDAuthDisabled      bool   `yaml:"guid_auth_disabled" env:"GUID_AUTH_DISABLED"`
+	AutoProvisionDisabled bool   `yaml:"auto_provision_disabled" env:"AUTO_PROVISION_DISABLED"`
+	TOTPIssuer            string `yaml:"totp_issuer" env:"TOTP_ISSUER"`
+}
+
+type WebAuthnConfig struct {
+	RPID   string `yaml:"rp_id" env:"WEBAUTHN_RP_ID"`
+	RPName string `yaml:"rp_name" env:"WEBAUTHN_RP_NAME"`
+	Origin string `yaml:"origin" env:"WEBAUTHN_ORIGIN"`
+}
+
+type MailConfig struct {
+	SMTPAddr         string `yaml:"smtp_addr" env:"SMTP_ADDR"`
+	SMTPUsername     string `yaml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword     Secret `yaml:"smtp_password" env:"SMTP_PASSWORD" secret:"smtp_password"`
+	From             string `yaml:"from" env:"MAIL_FROM"`
+	Dir              string `yaml:"dir" env:"MAIL_DIR"`
+	TemplatesDir     string `yaml:"templates_dir" env:"MAIL_TEMPLATES_DIR"`
+	EmailLoginURL    string `yaml:"email_login_url" env:"EMAIL_LOGIN_URL"`
+	PasswordResetURL string `yaml:"password_reset_url" env:"PASSWORD_RESET_URL"`
+	VerifyEmailURL   string `yaml:"verify_email_url" env:"VERIFY_EMAIL_URL"`
+}
+
+type SessionsConfig struct {
+	MaxPerUser   int    `yaml:"max_per_user" env:"MAX_SESSIONS_PER_USER"`
+	MaxPerClient int    `yaml:"max_per_client" env:"MAX_SESSIONS_PER_CLIENT"`
+	LimitPolicy  string `yaml:"limit_policy" env:"SESSION_LIMIT_POLICY"`
+}
+
+type Argon2Config struct {
+	Time    uint32 `yaml:"time" env:"ARGON2_TIME"`
+	Memory  uint32 `yaml:"memory" env:"ARGON2_MEMORY"`
+	Threads uint8  `yaml:"threads" env:"ARGON2_THREADS"`
+}
+
+// SecretsConfig selects where secrets are looked up. Values found by the
+// provider override the ones from the file, environment and flags.
+type SecretsConfig struct {
+	// Provider is file or vault, or empty to use the configured values.
+	Provider        string        `yaml:"provider" env:"SECRETS_PROVIDER"`
+	Dir             string        `yaml:"dir" env:"SECRETS_DIR"`
+	VaultAddr       string        `yaml:"vault_addr" env:"VAULT_ADDR"`
+	VaultToken      Secret        `yaml:"vault_token" env:"VAULT_TOKEN"`
+	VaultMount      string        `yaml:"vault_mount" env:"VAULT_MOUNT"`
+	VaultPath       string        `yaml:"vault_path" env:"VAULT_PATH"`
+	RefreshInterval time.Duration `yaml:"refresh_interval" env:"SECRETS_REFRESH_INTERVAL"`
+}
+
+// TLSConfig enables TLS on every listener when CertFile is set. The files
+// are reloaded when they change.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file" env:"TLS_CERT_FILE"`
+	KeyFile  string `yaml:"key_file" env:"TLS_KEY_FILE"`
+	// MinVersion is 1.2 or 1.3.
+	MinVersion string `yaml:"min_version" env:"TLS_MIN_VERSION"`
+	// CipherSuites is a comma separated list of TLS 1.2 cipher suite names,
+	// empty for the Go defaults.
+	CipherSuites string `yaml:"cipher_suites" env:"TLS_CIPHER_SUITES"`
+	ClientCAFile string `yaml:"client_ca_file" env:"TLS_CLIENT_CA_FILE"`
+	// ClientAuth is none, request or require.
+	ClientAuth     string        `yaml:"client_auth" env:"TLS_CLIENT_AUTH"`
+	ReloadInterval time.Duration `yaml:"reload_interval" env:"TLS_RELOAD_INTERVAL"`
+}
+
+// Default returns the configuration used for values that are not set.
+func Default() *Config {
+	return &Config{
+		Port:     "8080",
+		LogLevel: "info",
+		Tokens: TokensConfig{
+			AccessTTL:  token.DefaultAccessTTL,
+			RefreshTTL: token.DefaultRefreshTTL,
+			MFATTL:     token.DefaultMFATTL,
+		},
+		Auth: AuthConfig{
+			TOTPIssuer: "authservice",
+		},
+		Sessions: SessionsConfig{
+			LimitPolicy: "reject",
+		},
+		Argon2: Argon2Config{
+			Time:    password.DefaultParams.Time,
+			Memory:  password.DefaultParams.Memory,
+			Threads: password.DefaultParams.Threads,
+		},
+		Secrets: SecretsConfig{
+			Dir:             "/run/secrets",
+			VaultMount:      "secret",
+			RefreshInterval: time.Minute,
+		},
+		TLS: TLSConfig{
+			MinVersion:     "1.2",
+			ClientAuth:     "none",
+			ReloadInterval: time.Minute,
+		},
+	}
+}
+
+// Validate reports every invalid value at once.
+func (c *Config) Validate() error {
+	var problems []error
+
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.MongoDB.URI != "", "mongodb.uri is not set")
+	check(c.MongoDB.Name != "", "mongodb.name is not set")
+
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port > 0 && port <= 65535, "port is not a valid port: %q", c.Port)
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.LogLevel)) == nil, "log_level must be debug, info, warn or error: %q", c.LogLevel)
+
+	check(len(c.Tokens.AccessSecret) >= MinSecretLength, "tokens.access_secret must be at least %d bytes", MinSecretLength)
+	check(len(c.Tokens.RefreshSecret) >= MinSecretLength, "tokens.refresh_secret must be at least %d bytes", MinSecretLength)
+	check(c.Tokens.AccessTTL > 0, "tokens.access_ttl must be positive")
+	check(c.Tokens.RefreshTTL > c.Tokens.AccessTTL, "tokens.refresh_ttl must be longer than tokens.access_ttl")
+	check(c.Tokens.MFATTL > 0, "tokens.mfa_ttl must be positive")
+
+	check(c.Sessions.MaxPerUser >= 0, "sessions.max_per_user must not be negative")
+	check(c.Sessions.MaxPerClient >= 0, "sessions.max_per_client must not be negative")
+	check(c.Sessions.LimitPolicy == "reject" || c.Sessions.LimitPolicy == "evict_oldest",
+		"sessions.limit_policy must be reject or evict_oldest: %q", c.Sessions.LimitPolicy)
+
+	check(c.Argon2.Time > 0, "argon2.time must be positive")
+	check(c.Argon2.Memory > 0, "argon2.memory must be positive")
+	check(c.Argon2.Threads > 0, "argon2.threads must be positive")
+
+	check(c.WebAuthn.RPID == "" || c.WebAuthn.Origin != "", "webauthn.origin is required with webauthn.rp_id")
+
+	for _, u := range []struct{ name, value string }{
+		{"webauthn.origin", c.WebAuthn.Origin},
+		{"mail.email_login_url", c.Mail.EmailLoginURL},
+		{"mail.password_reset_url", c.Mail.PasswordResetURL},
+		{"mail.verify_email_url", c.Mail.VerifyEmailURL},
+	} {
+		if u.value != "" {
+			parsed, err := url.Parse(u.value)
+			check(err == nil && parsed.Scheme != "" && parsed.Host != "", "%s is not an absolute URL: %q", u.name, u.value)
+		}
+	}
+
+	switch c.Secrets.Provider {
+	case "", "file":
+	case "vault":
+		check(c.Secrets.VaultAddr != "", "secrets.vault_addr is required with the vault provider")
+		check(c.Secrets.VaultPath != "", "secrets.vault_path is required with the vault provider")
+	default:
+		check(false, "secrets.provider must be file or vault: %q", c.Secrets.Provider)
+	}
+	check(c.Secrets.RefreshInterval > 0, "secrets.refresh_interval must be positive")
+
+	_, err = tlsconf.ParseVersion(c.TLS.MinVersion)
+	check(err == nil, "tls.min_version must be 1.2 or 1.3: %q", c.TLS.MinVersion)
+
+	_, err = tlsconf.ParseCipherSuites(c.TLS.CipherSuites)
+	check(err == nil, "tls.cipher_suites: %v", err)
+
+	clientAuth, err := tlsconf.ParseClientAuth(c.TLS.ClientAuth)
+	check(err == nil, "tls.client_auth must be none, request or require: %q", c.TLS.ClientAuth)
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls.cert_file and tls.key_file must be set together")
+	check(c.TLS.ClientCAFile == "" || c.TLS.CertFile != "", "tls.client_ca_file requires tls.cert_file")
+	check(clientAuth == tls.NoClientCert || c.TLS.ClientCAFile != "", "tls.client_auth %s requires tls.client_ca_file", c.TLS.ClientAuth)
+	check(c.TLS.ReloadInterval > 0, "tls.reload_interval must be positive")
+
+	return errors.Join(problems...)
+}
+
+// SecretProvider returns the provider selected by c.Secrets, or nil if there
+// is none.
+func (c *Config) SecretProvider() secrets.Provider {
+	switch c.Secrets.Provider {
+	case "file":
+		return secrets.NewFileProvider(c.Secrets.Dir)
+	case "vault":
+		return secrets.NewVaultProvider(c.Secrets.VaultAddr, string(c.Secrets.VaultToken),
+			c.Secrets.VaultMount, c.Secrets.VaultPath, nil)
+	default:
+		return nil
+	}
+}
+
+// LoadSecrets sets the secret fields to the values p has for their secret
+// tag, keeping the current values of those it does not have. Providers that
+// implement secrets.Snapshotter are read once, so that all values come from
+// the same version.
+func (c *Config) LoadSecrets(ctx context.Context, p secrets.Provider) error {
+	if s, ok := p.(secrets.Snapshotter); ok {
+		snapshot, err := s.Snapshot(ctx)
+		if err != nil {
+			return err
+		}
+
+		p = snapshot
+	}
+	for _, f := range fieldsOf(reflect.ValueOf(c).Elem(), "") {
+		if f.secret == "" {
+			continue
+		}
+
+		value, err := p.Secret(ctx, f.secret)
+		if errors.Is(err, secrets.ErrNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.secret, err)
+		}
+
+		f.value.SetString(string(value))
+	}
+
+	return nil
+}
+
+// Redacted returns the configuration as YAML with secrets redacted.
+func (c *Config) Redacted() string {
+	var b strings.Builder
+
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(c); err != nil {
+		return err.Error()
+	}
+
+	return b.String()
+}
+
+// Changed lists the paths of the fields whose value differs between c and
+// other.
+func (c *Config) Changed(other *Config) []string {
+	var changed []string
+
+	otherFields := fieldsOf(reflect.ValueOf(other).Elem(), "")
+	for i, f := range fieldsOf(reflect.ValueOf(c).Elem(), "") {
+		if f.value.Interface() != otherFields[i].value.Interface() {
+			changed = append(changed, f.path)
+		}
+	}
+
+	return changed
+}
+
+// SlogLevel returns the minimum level of the messages to log.
+func (c *Config) SlogLevel() slog.Level {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.LogLevel))
+
+	return level
+}
+
+// TLSOptions returns the TLS settings of the servers, or nil if TLS is not
+// enabled. The configuration must be valid.
+func (c *Config) TLSOptions() *tlsconf.Options {
+	if c.TLS.CertFile == "" {
+		return nil
+	}
+
+	opts := &tlsconf.Options{
+		CertFile:     c.TLS.CertFile,
+		KeyFile:      c.TLS.KeyFile,
+		ClientCAFile: c.TLS.ClientCAFile,
+	}
+	opts.MinVersion, _ = tlsconf.ParseVersion(c.TLS.MinVersion)
+	opts.CipherSuites, _ = tlsconf.ParseCipherSuites(c.TLS.CipherSuites)
+	opts.ClientAuth, _ = tlsconf.ParseClientAuth(c.TLS.ClientAuth)
+
+	return opts
+}
+
+// Addr is the listen address of the HTTP server.
+func (c *Config) Addr() string {
+	return ":" + c.Port
+}
+
+// TokenConfig returns the token signing configuration.
+func (c *Config) TokenConfig() token.Config {
+	return token.Config{
+		AccessSecret:  []byte(c.Tokens.AccessSecret),
+		RefreshSecret: []byte(c.Tokens.RefreshSecret),
+		AccessTTL:     c.Tokens.AccessTTL,
+		RefreshTTL:    c.Tokens.RefreshTTL,
+		MFATTL:        c.Tokens.MFATTL,
+	}
+}
+
+// PasswordParams returns the argon2id parameters for new password hashes.
+func (c *Config) PasswordParams() password.Params {
+	params := password.DefaultParams
+	params.Time = c.Argon2.Time
+	params.Memory = c.Argon2.Memory
+	params.Threads = c.Argon2.Threads
+
+	return params
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the environment variable that can point to the configuration
+// file instead of the -config flag.
+const FileEnv = "CONFIG_FILE"
+
+// field is a configurable leaf of Config.
+type field struct {
+	path   string
+	env    string
+	secret string
+	value  reflect.Value
+}
+
+// Load reads the configuration from the defaults, the file named by -config
+// or CONFIG_FILE, the environment and the flags in args, each overriding the
+// previous ones. It registers its flags on flags, which may hold other flags
+// of the caller, and parses args. The configuration is not validated.
+func Load(flags *flag.FlagSet, args []string) (*Config, error) {
+	cfg := Default()
+	fields := fieldsOf(reflect.ValueOf(cfg).Elem(), "")
+
+	file := flags.String("config", os.Getenv(FileEnv), "YAML configuration file")
+
+	set := map[string]string{}
+	for _, f := range fields {
+		f := f
+		flags.Func(f.path, fmt.Sprintf("overrides $%s", f.env), func(s string) error {
+			if err := setField(f.value, s); err != nil {
+				return err
+			}
+
+			set[f.path] = s
+
+			return nil
+		})
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Flags were checked while parsing, reset the values so that the file
+	// and environment can be applied first.
+	*cfg = *Default()
+
+	if *file != "" {
+		b, err := os.ReadFile(*file)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := yaml.Unmarshal(b, cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", *file, err)
+		}
+	}
+
+	for _, f := range fields {
+		if s, ok := os.LookupEnv(f.env); ok && s != "" {
+			if err := setField(f.value, s); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.env, err)
+			}
+		}
+	}
+
+	for _, f := range fields {
+		if s, ok := set[f.path]; ok {
+			setField(f.value, s)
+		}
+	}
+
+	return cfg, nil
+}
+
+// fieldsOf lists the fields of the struct v that have an env tag, descending
+// into nested structs.
+func fieldsOf(v reflect.Value, prefix string) []field {
+	var fields []field
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		path := prefix + strings.Split(sf.Tag.Get("yaml"), ",")[0]
+
+		if sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, fieldsOf(v.Field(i), path+".")...)
+			continue
+		}
+
+		if env := sf.Tag.Get("env"); env != "" {
+			fields = append(fields, field{path: path, env: env, secret: sf.Tag.Get("secret"), value: v.Field(i)})
+		}
+	}
+
+	return fields
+}
+
+func setField(v reflect.Value, s string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+
+		v.SetInt(int64(d))
+
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.ParseInt(s, 10, 0)
+		if err != nil {
+			return err
+		}
+
+		v.SetInt(n)
+	case reflect.Uint8, reflect.Uint32:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		v.SetUint(n)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
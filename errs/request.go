@@ -1,9 +1,15 @@
 package errs
 
+import (
+	"net/http"
+	"time"
+)
+
 type RequestError struct {
-	Status  int
-	Message string
-	Err     error
+	Status     int
+	Message    string
+	Err        error
+	RetryAfter time.Duration
 }
 
 func (e *RequestError) Error() string {
@@ -17,3 +23,13 @@ func New(status int, message string, err error) *RequestError {
 		Err:     err,
 	}
 }
+
+// NewRetryAfter returns a Too Many Requests error telling the client to wait
+// retryAfter before trying again.
+func NewRetryAfter(message string, retryAfter time.Duration) *RequestError {
+	return &RequestError{
+		Status:     http.StatusTooManyRequests,
+		Message:    message,
+		RetryAfter: retryAfter,
+	}
+}
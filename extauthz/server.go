@@ -0,0 +1,156 @@
+// Package extauthz implements the Envoy external authorization gRPC API on
+// top of the access token verification of the usecase layer.
+package extauthz
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/token"
+	"github.com/flaambe/authservice/views"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const bearerSchema = "Bearer "
+
+// identityHeaders are set on allowed requests and always overwrite values
+// sent by the client.
+var identityHeaders = []string{"x-user-id", "x-user-roles", "x-user-scopes", "x-auth-methods"}
+
+type VerifyUsecase interface {
+	Verify(accessToken string, req views.VerifyRequest) (views.VerifyResponse, error)
+}
+
+// Server answers Envoy ext_authz checks. Required roles and scopes are read
+// from the roles and scopes context extensions of the route, or the
+// x-required-roles and x-required-scopes request headers.
+type Server struct {
+	authv3.UnimplementedAuthorizationServer
+
+	verifyUsecase VerifyUsecase
+}
+
+func NewServer(vu VerifyUsecase) *Server {
+	return &Server{
+		verifyUsecase: vu,
+	}
+}
+
+func (s *Server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	headers := httpReq.GetHeaders()
+	extensions := req.GetAttributes().GetContextExtensions()
+
+	authHeader := headers["authorization"]
+	if !strings.HasPrefix(authHeader, bearerSchema) {
+		return denied(codes.Unauthenticated, http.StatusUnauthorized, `Bearer`, "authorization requires Bearer scheme"), nil
+	}
+
+	verifyRequest := views.VerifyRequest{
+		Roles:          requiredList(extensions["roles"], headers["x-required-roles"]),
+		Scopes:         requiredList(extensions["scopes"], headers["x-required-scopes"]),
+		CertThumbprint: certThumbprint(req.GetAttributes().GetSource().GetCertificate()),
+	}
+
+	response, err := s.verifyUsecase.Verify(strings.TrimPrefix(authHeader, bearerSchema), verifyRequest)
+	if err != nil {
+		var requestErr *errs.RequestError
+		if !errors.As(err, &requestErr) {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+
+		switch requestErr.Status {
+		case http.StatusUnauthorized:
+			return denied(codes.Unauthenticated, requestErr.Status, `Bearer error="invalid_token"`, requestErr.Message), nil
+		case http.StatusForbidden:
+			return denied(codes.PermissionDenied, requestErr.Status, `Bearer error="insufficient_scope"`, requestErr.Message), nil
+		default:
+			return nil, status.Error(codes.Unavailable, requestErr.Message)
+		}
+	}
+
+	values := []string{
+		response.UserGUID,
+		strings.Join(response.Roles, ","),
+		strings.Join(response.Scopes, ","),
+		strings.Join(response.AMR, ","),
+	}
+
+	okResponse := &authv3.OkHttpResponse{}
+	for i, key := range identityHeaders {
+		okResponse.Headers = append(okResponse.Headers, &corev3.HeaderValueOption{
+			Header:       &corev3.HeaderValue{Key: key, Value: values[i]},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		})
+	}
+
+	return &authv3.CheckResponse{
+		Status:       &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: okResponse},
+	}, nil
+}
+
+// denied builds a denial that Envoy returns to the client as an HTTP response
+// with a JSON error body like the HTTP API.
+func denied(code codes.Code, httpStatus int, challenge, message string) *authv3.CheckResponse {
+	body, _ := json.Marshal(views.ErrorResponse{ErrorMessage: message})
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(code), Message: message},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status: &typev3.HttpStatus{Code: typev3.StatusCode(httpStatus)},
+				Headers: []*corev3.HeaderValueOption{
+					{Header: &corev3.HeaderValue{Key: "www-authenticate", Value: challenge}},
+					{Header: &corev3.HeaderValue{Key: "content-type", Value: "application/json"}},
+				},
+				Body: string(body),
+			},
+		},
+	}
+}
+
+// certThumbprint returns the thumbprint of the client certificate that Envoy
+// sends URL-encoded in PEM format when include_peer_certificate is set, or an
+// empty string if there is none.
+func certThumbprint(certificate string) string {
+	value, err := url.QueryUnescape(certificate)
+	if err != nil {
+		return ""
+	}
+
+	block, _ := pem.Decode([]byte(value))
+	if block == nil {
+		return ""
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return ""
+	}
+
+	return token.CertThumbprint(cert)
+}
+
+func requiredList(values ...string) []string {
+	for _, value := range values {
+		if list := strings.FieldsFunc(value, func(c rune) bool { return c == ',' || c == ' ' }); len(list) > 0 {
+			return list
+		}
+	}
+
+	return nil
+}
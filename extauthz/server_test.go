@@ -0,0 +1,117 @@
+package extauthz_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/extauthz"
+	"github.com/flaambe/authservice/token"
+	"github.com/flaambe/authservice/views"
+	"github.com/stretchr/testify/require"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/grpc/codes"
+)
+
+type verifier struct {
+	request views.VerifyRequest
+}
+
+func (v *verifier) Verify(accessToken string, req views.VerifyRequest) (views.VerifyResponse, error) {
+	v.request = req
+
+	if accessToken != "valid" {
+		return views.VerifyResponse{}, errs.New(http.StatusUnauthorized, "access token is invalid", nil)
+	}
+
+	return views.VerifyResponse{UserGUID: "4aa32cc5-d0e6-49e7-897d-d2b26748b7d3", Roles: []string{"staff"}}, nil
+}
+
+func checkRequest(headers, extensions map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{Headers: headers},
+			},
+			ContextExtensions: extensions,
+		},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	v := &verifier{}
+	server := extauthz.NewServer(v)
+
+	response, err := server.Check(context.Background(), checkRequest(
+		map[string]string{"authorization": "Bearer valid", "x-required-scopes": "reports:read"},
+		map[string]string{"roles": "staff,ops"},
+	))
+	require.NoError(t, err)
+	require.Equal(t, int32(codes.OK), response.GetStatus().GetCode())
+	require.Equal(t, []string{"staff", "ops"}, v.request.Roles)
+	require.Equal(t, []string{"reports:read"}, v.request.Scopes)
+
+	headers := map[string]string{}
+	for _, h := range response.GetOkResponse().GetHeaders() {
+		headers[h.GetHeader().GetKey()] = h.GetHeader().GetValue()
+	}
+
+	require.Equal(t, "4aa32cc5-d0e6-49e7-897d-d2b26748b7d3", headers["x-user-id"])
+	require.Equal(t, "staff", headers["x-user-roles"])
+
+	response, err = server.Check(context.Background(), checkRequest(map[string]string{"authorization": "Bearer expired"}, nil))
+	require.NoError(t, err)
+	require.Equal(t, int32(codes.Unauthenticated), response.GetStatus().GetCode())
+	require.Equal(t, typev3.StatusCode_Unauthorized, response.GetDeniedResponse().GetStatus().GetCode())
+
+	response, err = server.Check(context.Background(), checkRequest(map[string]string{}, nil))
+	require.NoError(t, err)
+	require.Equal(t, int32(codes.Unauthenticated), response.GetStatus().GetCode())
+}
+
+func TestCheckCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "reports"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	v := &verifier{}
+	server := extauthz.NewServer(v)
+
+	// Envoy sends the client certificate URL-encoded
+	req := checkRequest(map[string]string{"authorization": "Bearer valid"}, nil)
+	req.Attributes.Source = &authv3.AttributeContext_Peer{
+		Certificate: url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))),
+	}
+
+	_, err = server.Check(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, token.CertThumbprint(cert), v.request.CertThumbprint)
+
+	_, err = server.Check(context.Background(), checkRequest(map[string]string{"authorization": "Bearer valid"}, nil))
+	require.NoError(t, err)
+	require.Empty(t, v.request.CertThumbprint)
+}
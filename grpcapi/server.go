@@ -0,0 +1,263 @@
+// Package grpcapi serves the AuthService gRPC API defined in authpb on top of
+// the same usecase as the HTTP handlers.
+package grpcapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/flaambe/authservice/authpb"
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/token"
+	"github.com/flaambe/authservice/views"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const bearerSchema = "Bearer "
+
+type AuthUsecase interface {
+	Auth(guid string, client views.ClientInfo) (views.AuthResponse, error)
+	RefreshToken(accessToken, refreshToken string, client views.ClientInfo) (views.RefreshResponse, error)
+	DeleteToken(accessToken, refreshToken string, client views.ClientInfo) error
+	DeleteAllTokens(accessToken string, client views.ClientInfo) error
+	Verify(accessToken string, req views.VerifyRequest) (views.VerifyResponse, error)
+}
+
+type Server struct {
+	authpb.UnimplementedAuthServiceServer
+
+	authUsecase AuthUsecase
+	adminToken  string
+}
+
+// NewServer returns a Server on au. Introspect callers presenting adminToken,
+// when set, as bearer token may vouch for the certificate of their own
+// clients.
+func NewServer(au AuthUsecase, adminToken string) *Server {
+	return &Server{
+		authUsecase: au,
+		adminToken:  adminToken,
+	}
+}
+
+func (s *Server) Auth(ctx context.Context, req *authpb.AuthRequest) (*authpb.TokenResponse, error) {
+	if req.GetGuid() == "" {
+		return nil, status.Error(codes.InvalidArgument, "GUID not found")
+	}
+
+	response, err := s.authUsecase.Auth(req.GetGuid(), getClientInfo(ctx))
+	if err != nil {
+		return nil, usecaseError(ctx, err)
+	}
+
+	return &authpb.TokenResponse{
+		AccessToken:  response.AccessToken,
+		TokenType:    response.TokenType,
+		ExpiresIn:    int32(response.ExpiresIn),
+		RefreshToken: response.RefreshToken,
+	}, nil
+}
+
+func (s *Server) RefreshToken(ctx context.Context, req *authpb.RefreshTokenRequest) (*authpb.TokenResponse, error) {
+	accessToken, err := getBearer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetRefreshToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "refresh token is missing")
+	}
+
+	response, err := s.authUsecase.RefreshToken(accessToken, req.GetRefreshToken(), getClientInfo(ctx))
+	if err != nil {
+		return nil, usecaseError(ctx, err)
+	}
+
+	return &authpb.TokenResponse{
+		AccessToken:  response.AccessToken,
+		TokenType:    response.TokenType,
+		ExpiresIn:    int32(response.ExpiresIn),
+		RefreshToken: response.RefreshToken,
+	}, nil
+}
+
+func (s *Server) DeleteToken(ctx context.Context, req *authpb.DeleteTokenRequest) (*authpb.DeleteTokenResponse, error) {
+	accessToken, err := getBearer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetRefreshToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "refresh token is missing")
+	}
+
+	if err := s.authUsecase.DeleteToken(accessToken, req.GetRefreshToken(), getClientInfo(ctx)); err != nil {
+		return nil, usecaseError(ctx, err)
+	}
+
+	return &authpb.DeleteTokenResponse{}, nil
+}
+
+func (s *Server) DeleteAllTokens(ctx context.Context, req *authpb.DeleteAllTokensRequest) (*authpb.DeleteAllTokensResponse, error) {
+	accessToken, err := getBearer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authUsecase.DeleteAllTokens(accessToken, getClientInfo(ctx)); err != nil {
+		return nil, usecaseError(ctx, err)
+	}
+
+	return &authpb.DeleteAllTokensResponse{}, nil
+}
+
+// Introspect reports invalid, revoked and insufficiently privileged tokens as
+// inactive rather than failing the call. The certificate thumbprint of the
+// request is only trusted from callers presenting the admin token, others are
+// checked against the certificate they connected with, so that the bound
+// thumbprint read from a stolen token does not make it active.
+func (s *Server) Introspect(ctx context.Context, req *authpb.IntrospectRequest) (*authpb.IntrospectResponse, error) {
+	if req.GetToken() == "" {
+		return &authpb.IntrospectResponse{}, nil
+	}
+
+	certThumbprint := getClientInfo(ctx).CertThumbprint
+	if s.isAdmin(ctx) {
+		certThumbprint = req.GetCertThumbprint()
+	}
+
+	response, err := s.authUsecase.Verify(req.GetToken(), views.VerifyRequest{
+		Roles:          req.GetRoles(),
+		Scopes:         req.GetScopes(),
+		CertThumbprint: certThumbprint,
+	})
+
+	var requestErr *errs.RequestError
+	if errors.As(err, &requestErr) && (requestErr.Status == http.StatusUnauthorized || requestErr.Status == http.StatusForbidden) {
+		return &authpb.IntrospectResponse{}, nil
+	}
+
+	if err != nil {
+		return nil, usecaseError(ctx, err)
+	}
+
+	introspectResponse := &authpb.IntrospectResponse{
+		Active:    true,
+		UserId:    response.UserGUID,
+		Roles:     response.Roles,
+		Scopes:    response.Scopes,
+		Amr:       response.AMR,
+		ExpiresIn: int32(response.ExpiresIn),
+	}
+
+	if response.Cnf != nil {
+		introspectResponse.CertThumbprint = response.Cnf.CertThumbprint
+	}
+
+	return introspectResponse, nil
+}
+
+// isAdmin reports whether the caller presents the admin token.
+func (s *Server) isAdmin(ctx context.Context) bool {
+	if s.adminToken == "" {
+		return false
+	}
+
+	bearer, err := getBearer(ctx)
+
+	return err == nil && subtle.ConstantTimeCompare([]byte(bearer), []byte(s.adminToken)) == 1
+}
+
+func getBearer(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return "", status.Error(codes.Unauthenticated, "authorization metadata required")
+	}
+
+	if !strings.HasPrefix(values[0], bearerSchema) {
+		return "", status.Error(codes.Unauthenticated, "authorization requires Bearer scheme")
+	}
+
+	return strings.TrimPrefix(values[0], bearerSchema), nil
+}
+
+func getClientInfo(ctx context.Context) views.ClientInfo {
+	var client views.ClientInfo
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if ip, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			client.IP = ip
+		}
+
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.PeerCertificates) > 0 {
+			client.CertThumbprint = token.CertThumbprint(tlsInfo.State.PeerCertificates[0])
+		}
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("user-agent"); len(values) > 0 {
+		client.UserAgent = values[0]
+	}
+
+	if values := md.Get("x-session-name"); len(values) > 0 {
+		client.SessionName = values[0]
+	}
+
+	return client
+}
+
+// usecaseError converts err as returned by a usecase to a gRPC status,
+// logging the wrapped cause of an errs.RequestError.
+func usecaseError(ctx context.Context, err error) error {
+	var requestErr *errs.RequestError
+	if !errors.As(err, &requestErr) {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if requestErr.Err != nil {
+		slog.Error("Request failed", "err", requestErr.Err)
+	}
+
+	if requestErr.RetryAfter > 0 {
+		retryAfter := strconv.Itoa(int(math.Ceil(requestErr.RetryAfter.Seconds())))
+		_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter))
+	}
+
+	return status.Error(statusCode(requestErr.Status), requestErr.Message)
+}
+
+func statusCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusInternalServerError:
+		return codes.Internal
+	default:
+		return codes.Unknown
+	}
+}
@@ -0,0 +1,133 @@
+package grpcapi_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/flaambe/authservice/authpb"
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/grpcapi"
+	"github.com/flaambe/authservice/views"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type authUsecase struct {
+	client views.ClientInfo
+}
+
+func (a *authUsecase) Auth(guid string, client views.ClientInfo) (views.AuthResponse, error) {
+	a.client = client
+
+	return views.AuthResponse{AccessToken: "access", TokenType: "Bearer", ExpiresIn: 600, RefreshToken: "refresh"}, nil
+}
+
+func (a *authUsecase) RefreshToken(accessToken, refreshToken string, client views.ClientInfo) (views.RefreshResponse, error) {
+	return views.RefreshResponse{}, errs.NewRetryAfter("too many attempts", 30*time.Second)
+}
+
+func (a *authUsecase) DeleteToken(accessToken, refreshToken string, client views.ClientInfo) error {
+	if accessToken != "access" {
+		return errs.New(http.StatusForbidden, "access forbidden", nil)
+	}
+
+	return nil
+}
+
+func (a *authUsecase) DeleteAllTokens(accessToken string, client views.ClientInfo) error {
+	return nil
+}
+
+func (a *authUsecase) Verify(accessToken string, req views.VerifyRequest) (views.VerifyResponse, error) {
+	if accessToken == "bound" {
+		if req.CertThumbprint != "thumbprint" {
+			return views.VerifyResponse{}, errs.New(http.StatusUnauthorized, "access token is bound to another certificate", nil)
+		}
+
+		return views.VerifyResponse{UserGUID: "4aa32cc5-d0e6-49e7-897d-d2b26748b7d3", Cnf: &views.Confirmation{CertThumbprint: "thumbprint"}}, nil
+	}
+
+	if accessToken != "access" {
+		return views.VerifyResponse{}, errs.New(http.StatusUnauthorized, "access token is invalid", nil)
+	}
+
+	return views.VerifyResponse{UserGUID: "4aa32cc5-d0e6-49e7-897d-d2b26748b7d3", ExpiresIn: 600}, nil
+}
+
+func TestServer(t *testing.T) {
+	usecase := &authUsecase{}
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	authpb.RegisterAuthServiceServer(s, grpcapi.NewServer(usecase, "admin"))
+
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := authpb.NewAuthServiceClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-session-name", "laptop")
+
+	tokens, err := client.Auth(ctx, &authpb.AuthRequest{Guid: "4aa32cc5-d0e6-49e7-897d-d2b26748b7d3"})
+	require.NoError(t, err)
+	require.Equal(t, "access", tokens.GetAccessToken())
+	require.Equal(t, "laptop", usecase.client.SessionName)
+
+	_, err = client.Auth(ctx, &authpb.AuthRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Session calls take the access token from metadata
+	_, err = client.DeleteToken(ctx, &authpb.DeleteTokenRequest{RefreshToken: "refresh"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	authCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer other")
+	_, err = client.DeleteToken(authCtx, &authpb.DeleteTokenRequest{RefreshToken: "refresh"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	var header metadata.MD
+
+	authCtx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer access")
+	_, err = client.RefreshToken(authCtx, &authpb.RefreshTokenRequest{RefreshToken: "refresh"}, grpc.Header(&header))
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Equal(t, []string{"30"}, header.Get("retry-after"))
+
+	introspection, err := client.Introspect(ctx, &authpb.IntrospectRequest{Token: "access"})
+	require.NoError(t, err)
+	require.True(t, introspection.GetActive())
+	require.Equal(t, "4aa32cc5-d0e6-49e7-897d-d2b26748b7d3", introspection.GetUserId())
+
+	introspection, err = client.Introspect(ctx, &authpb.IntrospectRequest{Token: "expired"})
+	require.NoError(t, err)
+	require.False(t, introspection.GetActive())
+
+	// Certificate-bound tokens are only active with their certificate
+	introspection, err = client.Introspect(ctx, &authpb.IntrospectRequest{Token: "bound"})
+	require.NoError(t, err)
+	require.False(t, introspection.GetActive())
+
+	// Only the admin may vouch for the certificate of another client
+	introspection, err = client.Introspect(ctx, &authpb.IntrospectRequest{Token: "bound", CertThumbprint: "thumbprint"})
+	require.NoError(t, err)
+	require.False(t, introspection.GetActive())
+
+	adminCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer admin")
+	introspection, err = client.Introspect(adminCtx, &authpb.IntrospectRequest{Token: "bound", CertThumbprint: "thumbprint"})
+	require.NoError(t, err)
+	require.True(t, introspection.GetActive())
+	require.Equal(t, "thumbprint", introspection.GetCertThumbprint())
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/flaambe/authservice/views"
+)
+
+type AccountUsecase interface {
+	Register(username, email, password string) (views.RegisterResponse, error)
+	Login(login, password string, client views.ClientInfo) (views.LoginResponse, error)
+	ChangePassword(accessToken, oldPassword, newPassword string, client views.ClientInfo) error
+	RequestEmailLogin(email string, client views.ClientInfo) error
+	RedeemEmailLogin(linkToken, email, code string, client views.ClientInfo) (views.LoginResponse, error)
+	ForgotPassword(email string, client views.ClientInfo) error
+	ResetPassword(resetToken, newPassword string) error
+	RequestEmailVerification(accessToken string, client views.ClientInfo) error
+	VerifyEmail(verifyToken string) error
+}
+
+type AccountHandler struct {
+	accountUsecase AccountUsecase
+}
+
+func NewAccountHandler(au AccountUsecase) *AccountHandler {
+	return &AccountHandler{
+		accountUsecase: au,
+	}
+}
+
+func (h *AccountHandler) Register(w http.ResponseWriter, r *http.Request) {
+	var body views.RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithError(w, http.StatusBadRequest, "json is invalid: "+err.Error())
+
+		return
+	}
+
+	response, err := h.accountUsecase.Register(body.Username, body.Email, body.Password)
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (h *AccountHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var body views.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithError(w, http.StatusBadRequest, "json is invalid: "+err.Error())
+
+		return
+	}
+
+	if body.Login == "" || body.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "login and password required")
+		return
+	}
+
+	response, err := h.accountUsecase.Login(body.Login, body.Password, getClientInfo(r))
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (h *AccountHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var body views.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithError(w, http.StatusBadRequest, "json is invalid: "+err.Error())
+
+		return
+	}
+
+	accessToken, err := getBearer(r)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	err = h.accountUsecase.ChangePassword(accessToken, body.OldPassword, body.NewPassword, getClientInfo(r))
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AccountHandler) RequestEmailLogin(w http.ResponseWriter, r *http.Request) {
+	var body views.EmailLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithError(w, http.StatusBadRequest, "json is invalid: "+err.Error())
+
+		return
+	}
+
+	err := h.accountUsecase.RequestEmailLogin(body.Email, getClientInfo(r))
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *AccountHandler) RedeemEmailLogin(w http.ResponseWriter, r *http.Request) {
+	var body views.EmailLoginRedeemRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithError(w, http.StatusBadRequest, "json is invalid: "+err.Error())
+
+		return
+	}
+
+	response, err := h.accountUsecase.RedeemEmailLogin(body.Token, body.Email, body.Code, getClientInfo(r))
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (h *AccountHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var body views.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithError(w, http.StatusBadRequest, "json is invalid: "+err.Error())
+
+		return
+	}
+
+	err := h.accountUsecase.ForgotPassword(body.Email, getClientInfo(r))
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *AccountHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var body views.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithError(w, http.StatusBadRequest, "json is invalid: "+err.Error())
+
+		return
+	}
+
+	if body.Token == "" {
+		respondWithError(w, http.StatusBadRequest, "token is missing")
+		return
+	}
+
+	err := h.accountUsecase.ResetPassword(body.Token, body.NewPassword)
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AccountHandler) RequestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	accessToken, err := getBearer(r)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	err = h.accountUsecase.RequestEmailVerification(accessToken, getClientInfo(r))
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *AccountHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var body views.VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithError(w, http.StatusBadRequest, "json is invalid: "+err.Error())
+
+		return
+	}
+
+	if body.Token == "" {
+		respondWithError(w, http.StatusBadRequest, "token is missing")
+		return
+	}
+
+	err := h.accountUsecase.VerifyEmail(body.Token)
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/flaambe/authservice/views"
+
+	"github.com/gorilla/mux"
+)
+
+type AdminUsecase interface {
+	CreateUser(guid string) (views.UserResponse, error)
+	SetUserDisabled(guid string, disabled bool) (views.UserResponse, error)
+	SetUserGrants(guid string, roles, scopes []string) (views.UserResponse, error)
+	SearchUsers(req views.UserSearchRequest) (views.UserListResponse, error)
+	GetUser(guid string) (views.UserResponse, error)
+	ListUserSessions(guid string) ([]views.SessionResponse, error)
+	LogoutUser(guid string) error
+	DeleteUser(guid string) error
+	Stats() (views.StatsResponse, error)
+}
+
+type AdminHandler struct {
+	adminUsecase AdminUsecase
+}
+
+func NewAdminHandler(au AdminUsecase) *AdminHandler {
+	return &AdminHandler{
+		adminUsecase: au,
+	}
+}
+
+func (h *AdminHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var body views.CreateUserRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondWithError(w, http.StatusBadRequest, "json is invalid: "+err.Error())
+
+			return
+		}
+	}
+
+	response, err := h.adminUsecase.CreateUser(body.GUID)
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (h *AdminHandler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	response, err := h.adminUsecase.SetUserDisabled(mux.Vars(r)["guid"], true)
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (h *AdminHandler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	response, err := h.adminUsecase.SetUserDisabled(mux.Vars(r)["guid"], false)
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (h *AdminHandler) SetUserGrants(w http.ResponseWriter, r *http.Request) {
+	var body views.UserGrantsRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithError(w, http.StatusBadRequest, "json is invalid: "+err.Error())
+
+		return
+	}
+
+	response, err := h.adminUsecase.SetUserGrants(mux.Vars(r)["guid"], body.Roles, body.Scopes)
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// SearchUsers lists users matching the q, disabled, limit and offset query
+// parameters.
+func (h *AdminHandler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := views.UserSearchRequest{Query: query.Get("q")}
+
+	if s := query.Get("disabled"); s != "" {
+		disabled, err := strconv.ParseBool(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "disabled is incorrect")
+			return
+		}
+
+		req.Disabled = &disabled
+	}
+
+	for param, value := range map[string]*int64{"limit": &req.Limit, "offset": &req.Offset} {
+		if s := query.Get(param); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || n < 0 {
+				respondWithError(w, http.StatusBadRequest, param+" is incorrect")
+				return
+			}
+
+			*value = n
+		}
+	}
+
+	response, err := h.adminUsecase.SearchUsers(req)
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (h *AdminHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	response, err := h.adminUsecase.GetUser(mux.Vars(r)["guid"])
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (h *AdminHandler) ListUserSessions(w http.ResponseWriter, r *http.Request) {
+	response, err := h.adminUsecase.ListUserSessions(mux.Vars(r)["guid"])
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (h *AdminHandler) LogoutUser(w http.ResponseWriter, r *http.Request) {
+	err := h.adminUsecase.LogoutUser(mux.Vars(r)["guid"])
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	err := h.adminUsecase.DeleteUser(mux.Vars(r)["guid"])
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	response, err := h.adminUsecase.Stats()
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// RequireAdmin only lets through requests bearing adminToken, when set, or an
+// access token of a user with adminRole, when set.
+func RequireAdmin(adminToken, adminRole string, vu VerifyUsecase) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bearer, err := getBearer(r)
+			if err != nil {
+				respondWithError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+
+			if adminToken != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(adminToken)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if adminRole != "" {
+				if _, err := vu.Verify(bearer, views.VerifyRequest{Roles: []string{adminRole}, CertThumbprint: certThumbprint(r)}); err == nil {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			respondWithError(w, http.StatusForbidden, "access forbidden")
+		})
+	}
+}
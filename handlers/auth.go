@@ -3,8 +3,11 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
-	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/flaambe/authservice/errs"
@@ -16,10 +19,10 @@ const (
 )
 
 type AuthUsecase interface {
-	Auth(guid string) (views.AuthResponse, error)
-	RefreshToken(accessToken, refreshToken string) (views.RefreshResponse, error)
-	DeleteToken(accessToken, refreshToken string) error
-	DeleteAllTokens(accessToken string) error
+	Auth(guid string, client views.ClientInfo) (views.AuthResponse, error)
+	RefreshToken(accessToken, refreshToken string, client views.ClientInfo) (views.RefreshResponse, error)
+	DeleteToken(accessToken, refreshToken string, client views.ClientInfo) error
+	DeleteAllTokens(accessToken string, client views.ClientInfo) error
 }
 
 type AuthHandler struct {
@@ -45,21 +48,9 @@ func (h *AuthHandler) Auth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, err := h.authUsecase.Auth(body.GUID)
+	response, err := h.authUsecase.Auth(body.GUID, getClientInfo(r))
 	if err != nil {
-		var requestError *errs.RequestError
-		if errors.As(err, &requestError) {
-			if requestError.Err != nil {
-				log.Println(requestError.Err.Error())
-			}
-
-			respondWithError(w, requestError.Status, requestError.Message)
-
-			return
-		}
-
-		respondWithError(w, http.StatusInternalServerError, err.Error())
-
+		respondWithUsecaseError(w, err)
 		return
 	}
 
@@ -85,21 +76,9 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, err := h.authUsecase.RefreshToken(accessToken, body.RefreshToken)
+	response, err := h.authUsecase.RefreshToken(accessToken, body.RefreshToken, getClientInfo(r))
 	if err != nil {
-		var requestError *errs.RequestError
-		if errors.As(err, &requestError) {
-			if requestError.Err != nil {
-				log.Println(requestError.Err.Error())
-			}
-
-			respondWithError(w, requestError.Status, requestError.Message)
-
-			return
-		}
-
-		respondWithError(w, http.StatusInternalServerError, err.Error())
-
+		respondWithUsecaseError(w, err)
 		return
 	}
 
@@ -126,21 +105,9 @@ func (h *AuthHandler) DeleteToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.authUsecase.DeleteToken(accessToken, body.RefreshToken)
+	err = h.authUsecase.DeleteToken(accessToken, body.RefreshToken, getClientInfo(r))
 	if err != nil {
-		var requestError *errs.RequestError
-		if errors.As(err, &requestError) {
-			if requestError.Err != nil {
-				log.Println(requestError.Err.Error())
-			}
-
-			respondWithError(w, requestError.Status, requestError.Message)
-
-			return
-		}
-
-		respondWithError(w, http.StatusInternalServerError, err.Error())
-
+		respondWithUsecaseError(w, err)
 		return
 	}
 
@@ -154,21 +121,9 @@ func (h *AuthHandler) DeleteAllTokens(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.authUsecase.DeleteAllTokens(accessToken)
+	err = h.authUsecase.DeleteAllTokens(accessToken, getClientInfo(r))
 	if err != nil {
-		var requestErr *errs.RequestError
-		if errors.As(err, &requestErr) {
-			if requestErr.Err != nil {
-				log.Println(requestErr.Err.Error())
-			}
-
-			respondWithError(w, requestErr.Status, requestErr.Message)
-
-			return
-		}
-
-		respondWithError(w, http.StatusInternalServerError, err.Error())
-
+		respondWithUsecaseError(w, err)
 		return
 	}
 
@@ -189,6 +144,41 @@ func getBearer(req *http.Request) (string, error) {
 	return strings.ReplaceAll(authHeader, bearerSchema, ""), nil
 }
 
+// respondWithUsecaseError writes err as returned by a usecase, logging the
+// wrapped cause of an errs.RequestError.
+func respondWithUsecaseError(w http.ResponseWriter, err error) {
+	var requestErr *errs.RequestError
+	if errors.As(err, &requestErr) {
+		if requestErr.Err != nil {
+			slog.Error("Request failed", "err", requestErr.Err)
+		}
+
+		if requestErr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(requestErr.RetryAfter.Seconds()))))
+		}
+
+		respondWithError(w, requestErr.Status, requestErr.Message)
+
+		return
+	}
+
+	respondWithError(w, http.StatusInternalServerError, err.Error())
+}
+
+func getClientInfo(req *http.Request) views.ClientInfo {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+
+	return views.ClientInfo{
+		IP:             ip,
+		UserAgent:      req.UserAgent(),
+		SessionName:    req.Header.Get("X-Session-Name"),
+		CertThumbprint: certThumbprint(req),
+	}
+}
+
 func respondWithError(w http.ResponseWriter, code int, message string) {
 	respondWithJSON(w, code, views.ErrorResponse{ErrorMessage: message})
 }
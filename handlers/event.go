@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/flaambe/authservice/views"
+)
+
+// eventHeartbeat keeps idle event streams open through proxies.
+const eventHeartbeat = 15 * time.Second
+
+type EventUsecase interface {
+	SubscribeSecurityEvents(ctx context.Context, lastEventID int64) (<-chan views.SecurityEventResponse, error)
+}
+
+type EventHandler struct {
+	eventUsecase EventUsecase
+}
+
+func NewEventHandler(eu EventUsecase) *EventHandler {
+	return &EventHandler{
+		eventUsecase: eu,
+	}
+}
+
+// Stream pushes security events as server-sent events. Clients resume after
+// the event in the Last-Event-ID header or the last_event_id parameter.
+func (h *EventHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	var since int64
+
+	if lastEventID != "" {
+		var err error
+
+		since, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || since < 0 {
+			respondWithError(w, http.StatusBadRequest, "last event id is incorrect")
+			return
+		}
+	}
+
+	events, err := h.eventUsecase.SubscribeSecurityEvents(r.Context(), since)
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	// The stream outlives the server write timeout.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	rc.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				return
+			}
+
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
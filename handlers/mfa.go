@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/flaambe/authservice/views"
+)
+
+type MFAUsecase interface {
+	EnrollTOTP(accessToken string, client views.ClientInfo) (views.TOTPEnrollResponse, error)
+	ConfirmTOTP(accessToken, code string, client views.ClientInfo) (views.TOTPConfirmResponse, error)
+	LoginMFA(mfaToken, code, recoveryCode string, client views.ClientInfo) (views.AuthResponse, error)
+}
+
+type MFAHandler struct {
+	mfaUsecase MFAUsecase
+}
+
+func NewMFAHandler(mu MFAUsecase) *MFAHandler {
+	return &MFAHandler{
+		mfaUsecase: mu,
+	}
+}
+
+func (h *MFAHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	accessToken, err := getBearer(r)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	response, err := h.mfaUsecase.EnrollTOTP(accessToken, getClientInfo(r))
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (h *MFAHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var body views.TOTPConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithError(w, http.StatusBadRequest, "json is invalid: "+err.Error())
+
+		return
+	}
+
+	accessToken, err := getBearer(r)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	if body.Code == "" {
+		respondWithError(w, http.StatusBadRequest, "code is missing")
+		return
+	}
+
+	response, err := h.mfaUsecase.ConfirmTOTP(accessToken, body.Code, getClientInfo(r))
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (h *MFAHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var body views.MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithError(w, http.StatusBadRequest, "json is invalid: "+err.Error())
+
+		return
+	}
+
+	if body.MFAToken == "" {
+		respondWithError(w, http.StatusBadRequest, "mfa token is missing")
+		return
+	}
+
+	response, err := h.mfaUsecase.LoginMFA(body.MFAToken, body.Code, body.RecoveryCode, getClientInfo(r))
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
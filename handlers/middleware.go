@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/flaambe/authservice/token"
+
+	"github.com/gorilla/mux"
+)
+
+type clientCertKey struct{}
+
+// ProxyHeaders sets the request remote address from the X-Real-IP header or,
+// without one, the last X-Forwarded-For entry, which is the address the proxy
+// saw. Earlier entries are sent by the client and can not be trusted. It also
+// sets the client certificate from the X-Client-Cert header
+// holding the URL-escaped PEM or base64 DER certificate that the proxy
+// verified. Only use it behind a proxy that sets these headers, otherwise
+// clients can choose the IP they are throttled under and the certificate
+// their tokens are bound to.
+func ProxyHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := strings.TrimSpace(r.Header.Get("X-Real-IP"))
+		if ip == "" {
+			ip = lastForwardedFor(r.Header.Values("X-Forwarded-For"))
+		}
+
+		if net.ParseIP(ip) != nil {
+			r.RemoteAddr = net.JoinHostPort(ip, "0")
+		}
+
+		if header := r.Header.Get("X-Client-Cert"); header != "" {
+			cert, err := parseForwardedCert(header)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "X-Client-Cert is invalid")
+				return
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), clientCertKey{}, cert))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// lastForwardedFor returns the last address of the X-Forwarded-For header
+// values, which may be split over several header lines.
+func lastForwardedFor(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	entries := strings.Split(values[len(values)-1], ",")
+
+	return strings.TrimSpace(entries[len(entries)-1])
+}
+
+func parseForwardedCert(header string) (*x509.Certificate, error) {
+	value, err := url.QueryUnescape(header)
+	if err != nil {
+		return nil, err
+	}
+
+	if block, _ := pem.Decode([]byte(value)); block != nil {
+		return x509.ParseCertificate(block.Bytes)
+	}
+
+	der, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+// clientCert returns the certificate forwarded by the proxy or, without one,
+// the certificate presented on the TLS connection, or nil.
+func clientCert(r *http.Request) *x509.Certificate {
+	if cert, ok := r.Context().Value(clientCertKey{}).(*x509.Certificate); ok {
+		return cert
+	}
+
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0]
+	}
+
+	return nil
+}
+
+// certThumbprint returns the thumbprint of the client certificate of r, empty
+// if there is none.
+func certThumbprint(r *http.Request) string {
+	cert := clientCert(r)
+	if cert == nil {
+		return ""
+	}
+
+	return token.CertThumbprint(cert)
+}
+
+// RequireToken only lets through requests bearing token.
+func RequireToken(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bearer, err := getBearer(r)
+			if err != nil {
+				respondWithError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+
+			if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+				respondWithError(w, http.StatusForbidden, "access forbidden")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
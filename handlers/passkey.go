@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/flaambe/authservice/views"
+)
+
+type PasskeyUsecase interface {
+	BeginPasskeyRegistration(accessToken string, client views.ClientInfo) (views.PasskeyCreationResponse, error)
+	FinishPasskeyRegistration(accessToken string, request views.PasskeyFinishRequest, client views.ClientInfo) error
+	BeginPasskeyLogin(client views.ClientInfo) (views.PasskeyRequestResponse, error)
+	FinishPasskeyLogin(request views.PasskeyFinishRequest, client views.ClientInfo) (views.AuthResponse, error)
+}
+
+type PasskeyHandler struct {
+	passkeyUsecase PasskeyUsecase
+}
+
+func NewPasskeyHandler(pu PasskeyUsecase) *PasskeyHandler {
+	return &PasskeyHandler{
+		passkeyUsecase: pu,
+	}
+}
+
+func (h *PasskeyHandler) BeginRegistration(w http.ResponseWriter, r *http.Request) {
+	accessToken, err := getBearer(r)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	response, err := h.passkeyUsecase.BeginPasskeyRegistration(accessToken, getClientInfo(r))
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (h *PasskeyHandler) FinishRegistration(w http.ResponseWriter, r *http.Request) {
+	var body views.PasskeyFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithError(w, http.StatusBadRequest, "json is invalid: "+err.Error())
+
+		return
+	}
+
+	accessToken, err := getBearer(r)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	err = h.passkeyUsecase.FinishPasskeyRegistration(accessToken, body, getClientInfo(r))
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *PasskeyHandler) BeginLogin(w http.ResponseWriter, r *http.Request) {
+	response, err := h.passkeyUsecase.BeginPasskeyLogin(getClientInfo(r))
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (h *PasskeyHandler) FinishLogin(w http.ResponseWriter, r *http.Request) {
+	var body views.PasskeyFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithError(w, http.StatusBadRequest, "json is invalid: "+err.Error())
+
+		return
+	}
+
+	response, err := h.passkeyUsecase.FinishPasskeyLogin(body, getClientInfo(r))
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
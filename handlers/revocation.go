@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/flaambe/authservice/views"
+)
+
+type RevocationUsecase interface {
+	RevocationFeed(since int64) (views.RevocationFeedResponse, error)
+}
+
+type RevocationHandler struct {
+	revocationUsecase RevocationUsecase
+}
+
+func NewRevocationHandler(ru RevocationUsecase) *RevocationHandler {
+	return &RevocationHandler{
+		revocationUsecase: ru,
+	}
+}
+
+// Revocations serves the revoked access tokens. Verifiers pass the cursor of
+// their last response as since to receive only newer revocations.
+func (h *RevocationHandler) Revocations(w http.ResponseWriter, r *http.Request) {
+	var since int64
+
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+
+		since, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || since < 0 {
+			respondWithError(w, http.StatusBadRequest, "since is incorrect")
+			return
+		}
+	}
+
+	response, err := h.revocationUsecase.RevocationFeed(since)
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	// The feed for a given cursor only changes when new revocations arrive or
+	// listed tokens expire, which changes the cursor or the entry count.
+	etag := `"` + strconv.FormatInt(response.Cursor, 10) + `-` + strconv.Itoa(len(response.Revoked)) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=5")
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
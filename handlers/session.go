@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/flaambe/authservice/views"
+
+	"github.com/gorilla/mux"
+)
+
+type SessionUsecase interface {
+	ListSessions(accessToken string, client views.ClientInfo) ([]views.SessionResponse, error)
+	RevokeSession(accessToken, sessionID string, client views.ClientInfo) error
+}
+
+type SessionHandler struct {
+	sessionUsecase SessionUsecase
+}
+
+func NewSessionHandler(su SessionUsecase) *SessionHandler {
+	return &SessionHandler{
+		sessionUsecase: su,
+	}
+}
+
+func (h *SessionHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	accessToken, err := getBearer(r)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	response, err := h.sessionUsecase.ListSessions(accessToken, getClientInfo(r))
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (h *SessionHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	accessToken, err := getBearer(r)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	err = h.sessionUsecase.RevokeSession(accessToken, mux.Vars(r)["id"], getClientInfo(r))
+	if err != nil {
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
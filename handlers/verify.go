@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/views"
+)
+
+type VerifyUsecase interface {
+	Verify(accessToken string, req views.VerifyRequest) (views.VerifyResponse, error)
+}
+
+type VerifyHandler struct {
+	verifyUsecase VerifyUsecase
+}
+
+func NewVerifyHandler(vu VerifyUsecase) *VerifyHandler {
+	return &VerifyHandler{
+		verifyUsecase: vu,
+	}
+}
+
+// Verify is a forward-auth endpoint for reverse proxies. Required roles and
+// scopes come from the X-Required-Roles and X-Required-Scopes headers or the
+// roles and scopes parameters, separated by commas or spaces. The identity of
+// a valid token is returned in X-User-Id, X-User-Roles, X-User-Scopes and
+// X-Auth-Methods headers.
+func (h *VerifyHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	accessToken, err := getBearer(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	req := views.VerifyRequest{
+		Roles:          requiredList(r, "X-Required-Roles", "roles"),
+		Scopes:         requiredList(r, "X-Required-Scopes", "scopes"),
+		CertThumbprint: certThumbprint(r),
+	}
+
+	response, err := h.verifyUsecase.Verify(accessToken, req)
+	if err != nil {
+		var requestErr *errs.RequestError
+		if errors.As(err, &requestErr) {
+			switch requestErr.Status {
+			case http.StatusUnauthorized:
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			case http.StatusForbidden:
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+			}
+		}
+
+		respondWithUsecaseError(w, err)
+		return
+	}
+
+	w.Header().Set("X-User-Id", response.UserGUID)
+	w.Header().Set("X-User-Roles", strings.Join(response.Roles, ","))
+	w.Header().Set("X-User-Scopes", strings.Join(response.Scopes, ","))
+	w.Header().Set("X-Auth-Methods", strings.Join(response.AMR, ","))
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func requiredList(r *http.Request, header, param string) []string {
+	value := r.Header.Get(header)
+	if value == "" {
+		value = r.URL.Query().Get(param)
+	}
+
+	return strings.FieldsFunc(value, func(c rune) bool {
+		return c == ',' || c == ' '
+	})
+}
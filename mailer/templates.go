@@ -0,0 +1,108 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Template names used by the service.
+const (
+	EmailLoginTemplate    = "email_login"
+	PasswordResetTemplate = "password_reset"
+	VerifyEmailTemplate   = "verify_email"
+)
+
+var ErrInvalidTemplate = errors.New("mailer: template output must start with a Subject line")
+
+// Templates render messages. Every template produces a "Subject:" line, a
+// blank line and the body.
+type Templates struct {
+	t *template.Template
+}
+
+const defaultTemplates = `
+{{define "email_login"}}Subject: Your sign-in code
+
+Your sign-in code is {{.Code}}.
+{{if .Link}}
+Or follow this link to sign in:
+{{.Link}}
+{{end}}
+The code expires in {{.ExpiresInMinutes}} minutes. If you did not try to sign in, ignore this message.
+{{end}}
+
+{{define "password_reset"}}Subject: Reset your password
+
+Someone asked to reset the password of your account. Use this link to choose a new one:
+{{.Link}}
+
+The link expires in {{.ExpiresInMinutes}} minutes. If it was not you, ignore this message.
+{{end}}
+
+{{define "verify_email"}}Subject: Verify your email address
+
+Confirm that this address belongs to you by following this link:
+{{.Link}}
+
+The link expires in {{.ExpiresInMinutes}} minutes.
+{{end}}
+`
+
+// DefaultTemplates returns the built-in English templates.
+func DefaultTemplates() *Templates {
+	return &Templates{t: template.Must(template.New("").Parse(defaultTemplates))}
+}
+
+// LoadTemplates returns the built-in templates overridden by every <name>.tmpl
+// file in dir.
+func LoadTemplates(dir string) (*Templates, error) {
+	t := DefaultTemplates().t
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimSuffix(filepath.Base(file), ".tmpl")
+		if _, err := t.New(name).Parse(string(content)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Templates{t: t}, nil
+}
+
+// Render executes template name with data into a message for to.
+func (t *Templates) Render(name, to string, data interface{}) (Message, error) {
+	var b bytes.Buffer
+	if err := t.t.ExecuteTemplate(&b, name, data); err != nil {
+		return Message{}, err
+	}
+
+	out := strings.TrimLeft(b.String(), "\n")
+	if !strings.HasPrefix(out, "Subject: ") {
+		return Message{}, ErrInvalidTemplate
+	}
+
+	parts := strings.SplitN(out, "\n", 2)
+	body := ""
+	if len(parts) == 2 {
+		body = strings.TrimLeft(parts[1], "\n")
+	}
+
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(strings.TrimPrefix(parts[0], "Subject: ")),
+		Body:    body,
+	}, nil
+}
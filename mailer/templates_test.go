@@ -0,0 +1,43 @@
+package mailer_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flaambe/authservice/mailer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	data := struct {
+		Code             string
+		Link             string
+		ExpiresInMinutes int
+	}{"123456", "http://localhost/login?token=abc", 10}
+
+	msg, err := mailer.DefaultTemplates().Render(mailer.EmailLoginTemplate, "user@example.com", data)
+	require.NoError(t, err)
+	require.Equal(t, "user@example.com", msg.To)
+	require.Equal(t, "Your sign-in code", msg.Subject)
+	require.Contains(t, msg.Body, "123456")
+	require.Contains(t, msg.Body, data.Link)
+
+	dir := t.TempDir()
+
+	override := "Subject: Code {{.Code}}\n\nUse {{.Code}}.\n"
+	err = os.WriteFile(filepath.Join(dir, mailer.EmailLoginTemplate+".tmpl"), []byte(override), 0600)
+	require.NoError(t, err)
+
+	templates, err := mailer.LoadTemplates(dir)
+	require.NoError(t, err)
+
+	msg, err = templates.Render(mailer.EmailLoginTemplate, "user@example.com", data)
+	require.NoError(t, err)
+	require.Equal(t, "Code 123456", msg.Subject)
+	require.Equal(t, "Use 123456.\n", msg.Body)
+
+	// Templates that were not overridden are kept
+	_, err = templates.Render(mailer.PasswordResetTemplate, "user@example.com", data)
+	require.NoError(t, err)
+}
@@ -2,78 +2,175 @@ package main
 
 import (
 	"context"
-	"log"
-	"net/http"
+	"flag"
+	"fmt"
+	"log/slog"
 	"os"
-	"os/signal"
-	"syscall"
+	"strings"
 	"time"
 
-	"github.com/flaambe/authservice/handlers"
+	"github.com/flaambe/authservice/config"
 	"github.com/flaambe/authservice/mongoconf"
-	"github.com/flaambe/authservice/usecase"
-
-	"github.com/gorilla/mux"
 )
 
+const usage = `usage: authservice [command] [flags]
+
+commands:
+  serve           run the service (default)
+  migrate         apply pending database migrations
+  ensure-indexes  create missing indexes
+  check-config    validate the configuration and database connection
+`
+
+// logLevel is the minimum level of the messages logged, set from the
+// configuration.
+var logLevel slog.LevelVar
+
 func main() {
-	dbConfig := mongoconf.NewConfig()
-	if err := dbConfig.Open(os.Getenv("MONGODB_URI"), os.Getenv("DBNAME")); err != nil {
-		log.Fatal(err)
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: &logLevel})))
+
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "serve":
+		serve(args)
+	case "migrate":
+		migrate(args)
+	case "ensure-indexes":
+		ensureIndexes(args)
+	case "check-config":
+		checkConfig(args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// migrate applies pending migrations, or lists them with -status and
+// describes their steps with -dry-run.
+func migrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	status := flags.Bool("status", false, "list pending migrations without applying them")
+	dryRun := flags.Bool("dry-run", false, "describe the pending migration steps without applying them")
+	cfg := loadConfig(flags, args)
+
+	dbConfig := openDB(cfg)
+	defer closeDB(dbConfig)
+
+	if *status {
+		pending, err := dbConfig.PendingMigrations(context.Background())
+		if err != nil {
+			fatal("Listing migrations failed", err)
+		}
+
+		for _, m := range pending {
+			fmt.Printf("pending %d: %s\n", m.Version, m.Description)
+		}
+
+		return
+	}
+
+	reports, err := dbConfig.Migrate(context.Background(), *dryRun)
+	for _, r := range reports {
+		if *dryRun {
+			fmt.Printf("would apply %d: %s\n", r.Version, r.Description)
+		} else {
+			slog.Info("Applied migration", "version", r.Version, "description", r.Description)
+		}
+
+		for _, step := range r.Steps {
+			fmt.Printf("  %s\n", step)
+		}
+	}
+
+	if err != nil {
+		fatal("Migration failed", err)
 	}
+}
+
+func ensureIndexes(args []string) {
+	flags := flag.NewFlagSet("ensure-indexes", flag.ExitOnError)
+	cfg := loadConfig(flags, args)
+
+	dbConfig := openDB(cfg)
+	defer closeDB(dbConfig)
 
 	if err := dbConfig.EnsureIndexes(); err != nil {
-		log.Fatal(err)
+		fatal("Creating indexes failed", err)
 	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	defer dbConfig.DB.Client().Disconnect(ctx)
-
-	authUsecase := usecase.NewAuthUsecase(dbConfig.DB)
-	authHandler := handlers.NewAuthHandler(authUsecase)
-
-	router := mux.NewRouter()
-	router.HandleFunc("/auth", authHandler.Auth).Methods("POST")
-	router.HandleFunc("/refreshToken", authHandler.RefreshToken).Methods("POST")
-	router.HandleFunc("/deleteToken", authHandler.DeleteToken).Methods("POST")
-	router.HandleFunc("/deleteAllTokens", authHandler.DeleteAllTokens).Methods("POST")
-
-	srv := &http.Server{
-		Addr:         getPort(),
-		WriteTimeout: time.Second * 15,
-		ReadTimeout:  time.Second * 15,
-		IdleTimeout:  time.Second * 60,
-		Handler:      router,
+// loadConfig loads and validates the configuration, exiting on error.
+func loadConfig(flags *flag.FlagSet, args []string) *config.Config {
+	cfg, err := config.Load(flags, args)
+	if err != nil {
+		fatal("Loading configuration failed", err)
+	}
+
+	if err := loadSecrets(cfg); err != nil {
+		fatal("Loading secrets failed", err)
 	}
 
-	go func() {
-		panic(srv.ListenAndServe())
-	}()
+	if err := cfg.Validate(); err != nil {
+		fatal("Invalid configuration", err)
+	}
+
+	return cfg
+}
 
-	// Create channel for shutdown signals.
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
-	signal.Notify(stop, syscall.SIGTERM)
+// fatal logs msg and err at the error level and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
 
-	//Recieve shutdown signals.
-	<-stop
+// loadSecrets overrides the configured secrets with those of the secret
+// provider, if one is configured.
+func loadSecrets(cfg *config.Config) error {
+	provider := cfg.SecretProvider()
+	if provider == nil {
+		return nil
+	}
 
-	ctx, cancel = context.WithTimeout(context.Background(), time.Second*10)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("Error shutting down server %s", err)
-	} else {
-		log.Println("Server gracefully stopped")
+	return cfg.LoadSecrets(ctx, provider)
+}
+
+// openDB connects to the database, exiting if it can not be reached.
+func openDB(cfg *config.Config) *mongoconf.Config {
+	dbConfig, err := connectDB(cfg)
+	if err != nil {
+		fatal("Connecting to the database failed", err)
 	}
+
+	return dbConfig
 }
 
-func getPort() string {
-	p := os.Getenv("PORT")
-	if p != "" {
-		return ":" + p
+func connectDB(cfg *config.Config) (*mongoconf.Config, error) {
+	dbConfig := mongoconf.NewConfig()
+	if err := dbConfig.Open(string(cfg.MongoDB.URI), cfg.MongoDB.Name); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := dbConfig.DB.Client().Ping(ctx, nil); err != nil {
+		closeDB(dbConfig)
+		return nil, fmt.Errorf("database unreachable: %w", err)
 	}
 
-	return ":8080"
+	return dbConfig, nil
+}
+
+func closeDB(dbConfig *mongoconf.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dbConfig.DB.Client().Disconnect(ctx)
 }
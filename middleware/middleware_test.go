@@ -0,0 +1,184 @@
+package middleware_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flaambe/authservice/client"
+	"github.com/flaambe/authservice/middleware"
+	"github.com/flaambe/authservice/token"
+	"github.com/flaambe/authservice/views"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	claims["user_id"] = "4aa32cc5-d0e6-49e7-897d-d2b26748b7d3"
+	claims["exp"] = time.Now().Add(10 * time.Minute).Unix()
+
+	at := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		at.Header["kid"] = kid
+	}
+
+	signed, err := at.SignedString(key)
+	require.NoError(t, err)
+
+	return signed
+}
+
+func get(h http.Handler, accessToken string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/reports", nil)
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestHMACVerifier(t *testing.T) {
+	secret := []byte("access-secret")
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(views.RevocationFeedResponse{
+			Cursor:  1,
+			Full:    true,
+			Revoked: []views.RevokedTokenResponse{{JTI: "revoked", ExpiresAt: time.Now().Add(time.Minute).Unix()}},
+		})
+	}))
+	defer feed.Close()
+
+	revocations := middleware.NewRevocationList(client.New(feed.URL), 0, 0)
+	require.NoError(t, revocations.Sync(context.Background()))
+
+	verifier := middleware.NewHMACVerifier(secret, middleware.WithRevocationList(revocations))
+
+	var principal middleware.Principal
+
+	h := verifier.Middleware(middleware.RequireScope("reports:read")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = middleware.FromContext(r.Context())
+	})))
+
+	rec := get(h, signToken(t, jwt.SigningMethodHS512, secret, "", jwt.MapClaims{"jti": "valid", "scope": "reports:read", "roles": []string{"staff"}}))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "4aa32cc5-d0e6-49e7-897d-d2b26748b7d3", principal.UserGUID)
+	require.True(t, principal.HasRole("staff"))
+
+	rec = get(h, signToken(t, jwt.SigningMethodHS512, secret, "", jwt.MapClaims{"jti": "valid"}))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = get(h, signToken(t, jwt.SigningMethodHS512, secret, "", jwt.MapClaims{"jti": "revoked", "scope": "reports:read"}))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = get(h, signToken(t, jwt.SigningMethodHS512, []byte("other"), "", jwt.MapClaims{"jti": "valid", "scope": "reports:read"}))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = get(h, signToken(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"jti": "valid", "scope": "reports:read"}))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = get(h, "")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Certificate-bound tokens require the client certificate
+	cert := &x509.Certificate{Raw: []byte("client certificate")}
+	bound := signToken(t, jwt.SigningMethodHS512, secret, "", jwt.MapClaims{
+		"jti":   "valid",
+		"scope": "reports:read",
+		"cnf":   map[string]string{"x5t#S256": token.CertThumbprint(cert)},
+	})
+
+	rec = get(h, bound)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/reports", nil)
+	req.Header.Set("Authorization", "Bearer "+bound)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, token.CertThumbprint(cert), principal.CertThumbprint)
+}
+
+func TestStaleRevocations(t *testing.T) {
+	secret := []byte("access-secret")
+
+	var failing atomic.Bool
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(views.RevocationFeedResponse{Cursor: 1, Full: true})
+	}))
+	defer feed.Close()
+
+	revocations := middleware.NewRevocationList(client.New(feed.URL), time.Millisecond, 50*time.Millisecond)
+	h := middleware.NewHMACVerifier(secret, middleware.WithRevocationList(revocations)).Middleware(http.NotFoundHandler())
+	accessToken := signToken(t, jwt.SigningMethodHS512, secret, "", jwt.MapClaims{"jti": "valid"})
+
+	// Tokens are rejected until the first sync
+	rec := get(h, accessToken)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	require.NoError(t, revocations.Sync(context.Background()))
+
+	rec = get(h, accessToken)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	// and again once syncs have failed for longer than the bound
+	failing.Store(true)
+	require.Error(t, revocations.Sync(context.Background()))
+
+	time.Sleep(100 * time.Millisecond)
+
+	rec = get(h, accessToken)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestJWKSVerifier(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer jwksServer.Close()
+
+	verifier := middleware.NewJWKSVerifier(middleware.NewJWKS(jwksServer.URL, nil))
+
+	p, err := verifier.Verify(signToken(t, jwt.SigningMethodRS256, key, "key-1", jwt.MapClaims{"jti": "valid"}))
+	require.NoError(t, err)
+	require.Equal(t, "valid", p.JTI)
+
+	_, err = verifier.Verify(signToken(t, jwt.SigningMethodRS256, key, "key-2", jwt.MapClaims{"jti": "valid"}))
+	require.Error(t, err)
+
+	// HMAC tokens must not verify against public keys
+	_, err = verifier.Verify(signToken(t, jwt.SigningMethodHS256, key.N.Bytes(), "key-1", jwt.MapClaims{"jti": "valid"}))
+	require.Error(t, err)
+}
@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/flaambe/authservice/client"
+)
+
+// DefaultPollInterval is how often a RevocationList polls the feed.
+const DefaultPollInterval = 10 * time.Second
+
+// RevocationList mirrors the revocation feed of the service in memory. It is
+// safe for concurrent use.
+type RevocationList struct {
+	client       *client.Client
+	interval     time.Duration
+	maxStaleness time.Duration
+
+	mu       sync.RWMutex
+	cursor   int64
+	revoked  map[string]time.Time
+	lastSync time.Time
+}
+
+// NewRevocationList polls the feed through c every interval, or every
+// DefaultPollInterval when interval is zero. The list is only trusted for
+// maxStaleness after the last successful sync, six intervals when zero.
+func NewRevocationList(c *client.Client, interval, maxStaleness time.Duration) *RevocationList {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	if maxStaleness <= 0 {
+		maxStaleness = 6 * interval
+	}
+
+	return &RevocationList{
+		client:       c,
+		interval:     interval,
+		maxStaleness: maxStaleness,
+		revoked:      make(map[string]time.Time),
+	}
+}
+
+// Fresh reports whether the list synced within its maximum staleness. A list
+// that never synced is not fresh.
+func (rl *RevocationList) Fresh() bool {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	return !rl.lastSync.IsZero() && time.Since(rl.lastSync) <= rl.maxStaleness
+}
+
+// IsRevoked reports whether the token identified by jti is revoked.
+func (rl *RevocationList) IsRevoked(jti string) bool {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	_, ok := rl.revoked[jti]
+
+	return ok
+}
+
+// Run polls the feed until ctx is done. Poll errors are logged and retried at
+// the next interval.
+func (rl *RevocationList) Run(ctx context.Context) {
+	ticker := time.NewTicker(rl.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := rl.Sync(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("Revocation feed sync failed", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync fetches the revocations since the last sync and drops expired ones.
+func (rl *RevocationList) Sync(ctx context.Context) error {
+	rl.mu.RLock()
+	cursor := rl.cursor
+	rl.mu.RUnlock()
+
+	start := time.Now()
+
+	feed, err := rl.client.Revocations(ctx, cursor)
+	if err != nil {
+		return err
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if feed.Full {
+		rl.revoked = make(map[string]time.Time, len(feed.Revoked))
+	}
+
+	for _, r := range feed.Revoked {
+		rl.revoked[r.JTI] = time.Unix(r.ExpiresAt, 0)
+	}
+
+	now := time.Now()
+	for jti, exp := range rl.revoked {
+		if exp.Before(now) {
+			delete(rl.revoked, jti)
+		}
+	}
+
+	rl.cursor = feed.Cursor
+	rl.lastSync = start
+
+	return nil
+}
@@ -0,0 +1,247 @@
+// Package middleware verifies authservice access tokens in downstream HTTP
+// services and exposes the authenticated principal to handlers.
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/flaambe/authservice/token"
+	"github.com/flaambe/authservice/views"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const bearerSchema = "Bearer "
+
+var (
+	ErrMissingToken = errors.New("authorization requires Bearer token")
+	ErrRevokedToken = errors.New("token is revoked")
+	// ErrStaleRevocations is returned while the revocation list has not
+	// synced within its maximum staleness, so revoked tokens may pass.
+	ErrStaleRevocations = errors.New("revocation list is out of date")
+	// ErrCertificateMismatch is returned for a certificate-bound token used
+	// without the client certificate it is bound to.
+	ErrCertificateMismatch = errors.New("token is bound to another certificate")
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserGUID  string
+	JTI       string
+	Roles     []string
+	Scopes    []string
+	AMR       []string
+	ExpiresAt time.Time
+	// CertThumbprint is set for tokens bound to a client certificate.
+	CertThumbprint string
+}
+
+func (p Principal) HasRole(role string) bool {
+	return containsString(p.Roles, role)
+}
+
+func (p Principal) HasScope(scope string) bool {
+	return containsString(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+// FromContext returns the principal stored by Verifier.Middleware.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+
+	return p, ok
+}
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// Verifier checks access tokens signed with an HMAC secret or with keys
+// published as a JWKS, and optionally rejects revoked tokens.
+type Verifier struct {
+	keyFunc     jwt.Keyfunc
+	revocations *RevocationList
+}
+
+type Option func(*Verifier)
+
+// WithRevocationList rejects tokens listed by rl, and all tokens while rl is
+// not fresh.
+func WithRevocationList(rl *RevocationList) Option {
+	return func(v *Verifier) {
+		v.revocations = rl
+	}
+}
+
+// NewHMACVerifier verifies tokens signed with secret, the ACCESS_SECRET of
+// the service. Only HS512, which the service signs with, is accepted.
+func NewHMACVerifier(secret []byte, opts ...Option) *Verifier {
+	return newVerifier(func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS512 {
+			return nil, token.ErrInvalidToken
+		}
+
+		return secret, nil
+	}, opts)
+}
+
+// NewJWKSVerifier verifies RSA and ECDSA signed tokens with the keys of jwks.
+func NewJWKSVerifier(jwks *JWKS, opts ...Option) *Verifier {
+	return newVerifier(jwks.keyFunc, opts)
+}
+
+func newVerifier(keyFunc jwt.Keyfunc, opts []Option) *Verifier {
+	v := &Verifier{keyFunc: keyFunc}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	return v
+}
+
+// Verify returns the principal of a valid, unrevoked access token.
+func (v *Verifier) Verify(accessToken string) (Principal, error) {
+	claims, err := token.ParseAccessTokenWithKeyfunc(accessToken, v.keyFunc)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	if v.revocations != nil {
+		if !v.revocations.Fresh() {
+			return Principal{}, ErrStaleRevocations
+		}
+
+		if v.revocations.IsRevoked(claims.JTI) {
+			return Principal{}, ErrRevokedToken
+		}
+	}
+
+	return Principal{
+		UserGUID:       claims.UserGUID,
+		JTI:            claims.JTI,
+		Roles:          claims.Roles,
+		Scopes:         claims.Scopes,
+		AMR:            claims.AMR,
+		ExpiresAt:      claims.ExpiresAt,
+		CertThumbprint: claims.CertThumbprint,
+	}, nil
+}
+
+// Middleware rejects requests without a valid bearer token with 401, and all
+// requests with 503 while the revocation list is out of date. It stores the
+// principal in the request context of the others. Tokens bound to
+// a client certificate must be presented over a TLS connection authenticated
+// with that certificate.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authHeader, bearerSchema) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			respondWithError(w, http.StatusUnauthorized, ErrMissingToken.Error())
+			return
+		}
+
+		p, err := v.Verify(strings.TrimPrefix(authHeader, bearerSchema))
+		if err == nil && p.CertThumbprint != "" && p.CertThumbprint != peerThumbprint(r) {
+			err = ErrCertificateMismatch
+		}
+
+		if errors.Is(err, ErrStaleRevocations) {
+			respondWithError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), p)))
+	})
+}
+
+// RequireScope only lets through requests whose principal has all scopes.
+// It must run after Verifier.Middleware.
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return require(func(p Principal) string {
+		for _, scope := range scopes {
+			if !p.HasScope(scope) {
+				return "missing scope " + scope
+			}
+		}
+
+		return ""
+	})
+}
+
+// RequireRole only lets through requests whose principal has all roles. It
+// must run after Verifier.Middleware.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return require(func(p Principal) string {
+		for _, role := range roles {
+			if !p.HasRole(role) {
+				return "missing role " + role
+			}
+		}
+
+		return ""
+	})
+}
+
+// require wraps handlers with a check that returns why the principal is
+// denied, or an empty string.
+func require(check func(Principal) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := FromContext(r.Context())
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				respondWithError(w, http.StatusUnauthorized, ErrMissingToken.Error())
+				return
+			}
+
+			if reason := check(p); reason != "" {
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+				respondWithError(w, http.StatusForbidden, reason)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func peerThumbprint(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+
+	return token.CertThumbprint(r.TLS.PeerCertificates[0])
+}
+
+func respondWithError(w http.ResponseWriter, code int, message string) {
+	response, _ := json.Marshal(views.ErrorResponse{ErrorMessage: message})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(response)
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
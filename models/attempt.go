@@ -0,0 +1,12 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// LoginAttempts counts recent authentication failures, and attempts still in
+// progress, for an account or a source IP, identified by Key.
+type LoginAttempts struct {
+	Key         string             `bson:"_id"`
+	Failures    int                `bson:"failures"`
+	LockedUntil primitive.DateTime `bson:"locked_until,omitempty"`
+	ExpiresAt   primitive.DateTime `bson:"expires_at"`
+}
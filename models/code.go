@@ -0,0 +1,15 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// OneTimeCode is a single-use secret sent to a user out of band. Only hashes
+// of the link token and of the short code are stored.
+type OneTimeCode struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Purpose   string             `bson:"purpose"`
+	TokenHash string             `bson:"token_hash"`
+	CodeHash  string             `bson:"code_hash,omitempty"`
+	Attempts  int                `bson:"attempts"`
+	ExpiresAt primitive.DateTime `bson:"expires_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Security event types.
+const (
+	EventSessionRevoked = "session.revoked"
+	EventUserLoggedOut  = "user.logged_out"
+	EventKeyRotated     = "key.rotated"
+)
+
+// SecurityEvent is a revocation or key change pushed to event stream
+// subscribers. Seq orders events and lets subscribers resume.
+type SecurityEvent struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Seq       int64              `bson:"seq"`
+	Type      string             `bson:"type"`
+	UserGUID  string             `bson:"user_guid,omitempty"`
+	SessionID string             `bson:"session_id,omitempty"`
+	JTIs      []string           `bson:"jtis,omitempty"`
+	KeyID     string             `bson:"kid,omitempty"`
+	CreatedAt primitive.DateTime `bson:"created_at"`
+	ExpiresAt primitive.DateTime `bson:"expires_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// RevokedToken records an access token revoked before its expiry. Seq orders
+// revocations for incremental feeds.
+type RevokedToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	JTI       string             `bson:"jti"`
+	Seq       int64              `bson:"seq"`
+	RevokedAt primitive.DateTime `bson:"revoked_at"`
+	ExpiresAt primitive.DateTime `bson:"expires_at"`
+}
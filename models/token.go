@@ -9,7 +9,17 @@ type AuthToken struct {
 	UserID           primitive.ObjectID `bson:"user_id,omitempty"`
 	TokenType        string             `bson:"token_type"`
 	AccessToken      string             `bson:"access_token"`
+	JTI              string             `bson:"jti,omitempty"`
 	RefreshToken     string             `bson:"refresh_token"`
 	AccessExpiresAt  primitive.DateTime `bson:"access_expires_at"`
 	RefreshExpiresAt primitive.DateTime `bson:"refresh_expires_at"`
+	AMR              []string           `bson:"amr,omitempty"`
+	Name             string             `bson:"name,omitempty"`
+	UserAgent        string             `bson:"user_agent,omitempty"`
+	ClientIP         string             `bson:"client_ip,omitempty"`
+	CreatedAt        primitive.DateTime `bson:"created_at"`
+	LastRefreshedAt  primitive.DateTime `bson:"last_refreshed_at,omitempty"`
+	// CertThumbprint is the thumbprint of the client certificate the tokens
+	// are bound to.
+	CertThumbprint string `bson:"cert_thumbprint,omitempty"`
 }
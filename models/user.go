@@ -3,6 +3,18 @@ package models
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
 type User struct {
-	ID   primitive.ObjectID `bson:"_id,omitempty"`
-	GUID string             `bson:"guid"`
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	GUID          string             `bson:"guid"`
+	Username      string             `bson:"username,omitempty"`
+	Email         string             `bson:"email,omitempty"`
+	EmailVerified bool               `bson:"email_verified"`
+	PasswordHash  string             `bson:"password_hash,omitempty"`
+	Disabled      bool               `bson:"disabled"`
+	Roles         []string           `bson:"roles,omitempty"`
+	Scopes        []string           `bson:"scopes,omitempty"`
+
+	TOTPSecret    string   `bson:"totp_secret,omitempty"`
+	TOTPEnabled   bool     `bson:"totp_enabled"`
+	TOTPLastStep  int64    `bson:"totp_last_step,omitempty"`
+	RecoveryCodes []string `bson:"recovery_codes,omitempty"`
 }
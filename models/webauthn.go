@@ -0,0 +1,21 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type WebAuthnCredential struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	UserID       primitive.ObjectID `bson:"user_id"`
+	CredentialID []byte             `bson:"credential_id"`
+	PublicKey    []byte             `bson:"public_key"`
+	SignCount    int64              `bson:"sign_count"`
+	CreatedAt    primitive.DateTime `bson:"created_at"`
+	LastUsedAt   primitive.DateTime `bson:"last_used_at,omitempty"`
+}
+
+type WebAuthnChallenge struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id,omitempty"`
+	Ceremony  string             `bson:"ceremony"`
+	Challenge []byte             `bson:"challenge"`
+	ExpiresAt primitive.DateTime `bson:"expires_at"`
+}
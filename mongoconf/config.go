@@ -4,7 +4,6 @@ import (
 	"context"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -31,25 +30,17 @@ func (c *Config) Open(mongoURI, dbName string) error {
 	return nil
 }
 
+// EnsureIndexes creates the indexes of every migration, so that they exist
+// even when migrations are run as a separate job.
 func (c *Config) EnsureIndexes() error {
-	uniqUserIndex := mongo.IndexModel{
-		Keys:    bson.M{"guid": 1},
-		Options: options.Index().SetUnique(true),
-	}
-
-	_, err := c.DB.Collection("users").Indexes().CreateOne(context.TODO(), uniqUserIndex)
-	if err != nil {
-		return err
-	}
-
-	expireTokenIndex := mongo.IndexModel{
-		Keys:    bson.M{"refresh_expires_at": 1},
-		Options: options.Index().SetExpireAfterSeconds(0),
-	}
-
-	_, err = c.DB.Collection("tokens").Indexes().CreateOne(context.TODO(), expireTokenIndex)
-	if err != nil {
-		return err
+	for _, m := range Migrations {
+		for _, step := range m.Steps {
+			if index, ok := step.(IndexStep); ok {
+				if err := index.Apply(context.TODO(), c.DB); err != nil {
+					return err
+				}
+			}
+		}
 	}
 
 	return nil
@@ -0,0 +1,258 @@
+package mongoconf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrationLockTTL bounds how long a crashed instance keeps other instances
+// from migrating. The lock is renewed after each migration.
+const migrationLockTTL = 10 * time.Minute
+
+// ErrMigrationLocked is returned by Migrate while another instance holds the
+// migration lock.
+var ErrMigrationLocked = errors.New("another instance is migrating the database")
+
+// Migration is a versioned change to the database schema or data. Applied
+// versions are recorded in the migrations collection and never run again.
+type Migration struct {
+	Version     int
+	Description string
+	Steps       []Step
+}
+
+// Step is a single change made by a migration.
+type Step interface {
+	// Describe reports what Apply would change without changing anything.
+	Describe(ctx context.Context, db *mongo.Database) (string, error)
+	Apply(ctx context.Context, db *mongo.Database) error
+}
+
+// IndexStep creates an index on Collection. Creating an existing index is a
+// no-op.
+type IndexStep struct {
+	Collection string
+	Index      mongo.IndexModel
+}
+
+func (s IndexStep) Describe(ctx context.Context, db *mongo.Database) (string, error) {
+	return fmt.Sprintf("create index %v on %s", s.Index.Keys, s.Collection), nil
+}
+
+func (s IndexStep) Apply(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(s.Collection).Indexes().CreateOne(ctx, s.Index)
+
+	return err
+}
+
+// UpdateStep applies Update, a document or an aggregation pipeline, to the
+// documents of Collection matching Filter. Filter must stop matching the
+// documents once they are updated so that the step can be retried.
+type UpdateStep struct {
+	Collection string
+	Filter     interface{}
+	Update     interface{}
+}
+
+func (s UpdateStep) Describe(ctx context.Context, db *mongo.Database) (string, error) {
+	count, err := db.Collection(s.Collection).CountDocuments(ctx, s.Filter)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("update %d documents in %s", count, s.Collection), nil
+}
+
+func (s UpdateStep) Apply(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(s.Collection).UpdateMany(ctx, s.Filter, s.Update)
+
+	return err
+}
+
+// AppliedMigration records a migration that ran.
+type AppliedMigration struct {
+	Version     int                `bson:"_id"`
+	Description string             `bson:"description"`
+	AppliedAt   primitive.DateTime `bson:"applied_at"`
+}
+
+// MigrationReport describes a migration run, or to be run on a dry run, with
+// the description of each of its steps.
+type MigrationReport struct {
+	Version     int
+	Description string
+	Steps       []string
+}
+
+// Migrate applies the migrations that have not been applied yet, in order,
+// and reports them. With dryRun it only reports what would be done. Only one
+// instance migrates at a time, others get ErrMigrationLocked.
+func (c *Config) Migrate(ctx context.Context, dryRun bool) ([]MigrationReport, error) {
+	if dryRun {
+		pending, err := c.PendingMigrations(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var reports []MigrationReport
+		for _, m := range pending {
+			report, err := describeMigration(ctx, c.DB, m)
+			if err != nil {
+				return reports, err
+			}
+
+			reports = append(reports, report)
+		}
+
+		return reports, nil
+	}
+
+	owner := lockOwner()
+	if err := c.lockMigrations(ctx, owner); err != nil {
+		return nil, err
+	}
+	defer c.unlockMigrations(owner)
+
+	// Read the applied versions once locked so that migrations applied by
+	// the previous lock holder are not run again.
+	pending, err := c.PendingMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := c.DB.Collection("migrations")
+
+	var reports []MigrationReport
+	for _, m := range pending {
+		report, err := describeMigration(ctx, c.DB, m)
+		if err != nil {
+			return reports, err
+		}
+
+		for _, step := range m.Steps {
+			if err := step.Apply(ctx, c.DB); err != nil {
+				return reports, fmt.Errorf("migration %d: %w", m.Version, err)
+			}
+		}
+
+		_, err = applied.InsertOne(ctx, AppliedMigration{
+			Version:     m.Version,
+			Description: m.Description,
+			AppliedAt:   primitive.NewDateTimeFromTime(time.Now()),
+		})
+		if err != nil {
+			return reports, err
+		}
+
+		reports = append(reports, report)
+
+		if err := c.lockMigrations(ctx, owner); err != nil {
+			return reports, err
+		}
+	}
+
+	return reports, nil
+}
+
+// PendingMigrations returns the migrations that have not been applied yet.
+func (c *Config) PendingMigrations(ctx context.Context) ([]Migration, error) {
+	cursor, err := c.DB.Collection("migrations").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []AppliedMigration
+	if err := cursor.All(ctx, &applied); err != nil {
+		return nil, err
+	}
+
+	done := make(map[int]bool, len(applied))
+	for _, a := range applied {
+		done[a.Version] = true
+	}
+
+	var pending []Migration
+	for _, m := range Migrations {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending, nil
+}
+
+// lockMigrations takes or renews the migration lock for owner. The lock
+// document is upserted only when it has expired or is already owned, so the
+// insert fails with a duplicate key while another owner holds it.
+func (c *Config) lockMigrations(ctx context.Context, owner string) error {
+	now := time.Now()
+
+	filter := bson.M{
+		"_id": "migrations",
+		"$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expires_at": bson.M{"$lt": primitive.NewDateTimeFromTime(now)}},
+		},
+	}
+	update := bson.M{"$set": bson.M{
+		"owner":      owner,
+		"expires_at": primitive.NewDateTimeFromTime(now.Add(migrationLockTTL)),
+	}}
+
+	_, err := c.DB.Collection("locks").UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if isDuplicateKeyError(err) {
+		return ErrMigrationLocked
+	}
+
+	return err
+}
+
+func (c *Config) unlockMigrations(owner string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c.DB.Collection("locks").DeleteOne(ctx, bson.M{"_id": "migrations", "owner": owner})
+}
+
+func describeMigration(ctx context.Context, db *mongo.Database, m Migration) (MigrationReport, error) {
+	report := MigrationReport{Version: m.Version, Description: m.Description}
+
+	for _, step := range m.Steps {
+		description, err := step.Describe(ctx, db)
+		if err != nil {
+			return report, fmt.Errorf("migration %d: %w", m.Version, err)
+		}
+
+		report.Steps = append(report.Steps, description)
+	}
+
+	return report, nil
+}
+
+func lockOwner() string {
+	hostname, _ := os.Hostname()
+
+	return fmt.Sprintf("%s/%d/%s", hostname, os.Getpid(), uuid.New().String())
+}
+
+func isDuplicateKeyError(err error) bool {
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, writeError := range writeException.WriteErrors {
+			if writeError.Code == 11000 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
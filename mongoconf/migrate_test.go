@@ -0,0 +1,94 @@
+package mongoconf_test
+
+import (
+	"context"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/flaambe/authservice/models"
+	"github.com/flaambe/authservice/mongoconf"
+	"github.com/stretchr/testify/require"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var dbConfig *mongoconf.Config
+
+func TestMain(m *testing.M) {
+	dbConfig = mongoconf.NewConfig()
+	if err := dbConfig.Open(os.Getenv("MONGODB_TEST_URI"), os.Getenv("DBNAME_TEST")+"_migrations"); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := dbConfig.DB.Drop(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+
+	exitVal := m.Run()
+
+	_ = dbConfig.DB.Drop(context.TODO())
+	_ = dbConfig.DB.Client().Disconnect(context.TODO())
+
+	os.Exit(exitVal)
+}
+
+func TestMigrationVersions(t *testing.T) {
+	for i, m := range mongoconf.Migrations {
+		require.Equal(t, i+1, m.Version)
+		require.NotEmpty(t, m.Description)
+		require.NotEmpty(t, m.Steps)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+
+	tokenID := primitive.NewObjectIDFromTimestamp(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, err := dbConfig.DB.Collection("tokens").InsertOne(ctx, bson.M{"_id": tokenID, "token_type": "Bearer"})
+	require.NoError(t, err)
+
+	// A dry run changes nothing
+	reports, err := dbConfig.Migrate(ctx, true)
+	require.NoError(t, err)
+	require.Len(t, reports, len(mongoconf.Migrations))
+	require.Contains(t, reports[1].Steps, "update 1 documents in tokens")
+
+	pending, err := dbConfig.PendingMigrations(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, len(mongoconf.Migrations))
+
+	// Another instance holds the lock
+	_, err = dbConfig.DB.Collection("locks").InsertOne(ctx, bson.M{
+		"_id":        "migrations",
+		"owner":      "other",
+		"expires_at": primitive.NewDateTimeFromTime(time.Now().Add(time.Minute)),
+	})
+	require.NoError(t, err)
+
+	_, err = dbConfig.Migrate(ctx, false)
+	require.ErrorIs(t, err, mongoconf.ErrMigrationLocked)
+
+	_, err = dbConfig.DB.Collection("locks").DeleteOne(ctx, bson.M{"_id": "migrations"})
+	require.NoError(t, err)
+
+	reports, err = dbConfig.Migrate(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, reports, len(mongoconf.Migrations))
+
+	token := models.AuthToken{}
+	err = dbConfig.DB.Collection("tokens").FindOne(ctx, bson.M{"_id": tokenID}).Decode(&token)
+	require.NoError(t, err)
+	require.Equal(t, tokenID.Timestamp().UTC(), token.CreatedAt.Time().UTC())
+
+	// Applied migrations are not run again and the lock is released
+	reports, err = dbConfig.Migrate(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, reports)
+
+	count, err := dbConfig.DB.Collection("locks").CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
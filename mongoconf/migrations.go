@@ -0,0 +1,89 @@
+package mongoconf
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migrations lists the migrations in version order. New ones are appended;
+// applied ones must not be changed.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create indexes",
+		Steps: []Step{
+			IndexStep{Collection: "users", Index: mongo.IndexModel{
+				Keys:    bson.M{"guid": 1},
+				Options: options.Index().SetUnique(true),
+			}},
+			IndexStep{Collection: "users", Index: mongo.IndexModel{
+				Keys:    bson.M{"username": 1},
+				Options: options.Index().SetUnique(true).SetSparse(true),
+			}},
+			IndexStep{Collection: "users", Index: mongo.IndexModel{
+				Keys:    bson.M{"email": 1},
+				Options: options.Index().SetUnique(true).SetSparse(true),
+			}},
+			IndexStep{Collection: "tokens", Index: mongo.IndexModel{
+				Keys:    bson.M{"refresh_expires_at": 1},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			}},
+			IndexStep{Collection: "tokens", Index: mongo.IndexModel{
+				Keys: bson.M{"user_id": 1},
+			}},
+			IndexStep{Collection: "webauthn_credentials", Index: mongo.IndexModel{
+				Keys:    bson.M{"credential_id": 1},
+				Options: options.Index().SetUnique(true),
+			}},
+			IndexStep{Collection: "webauthn_challenges", Index: mongo.IndexModel{
+				Keys:    bson.M{"expires_at": 1},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			}},
+			IndexStep{Collection: "one_time_codes", Index: mongo.IndexModel{
+				Keys: bson.M{"token_hash": 1},
+			}},
+			IndexStep{Collection: "one_time_codes", Index: mongo.IndexModel{
+				Keys:    bson.M{"expires_at": 1},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			}},
+			IndexStep{Collection: "login_attempts", Index: mongo.IndexModel{
+				Keys:    bson.M{"expires_at": 1},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			}},
+			IndexStep{Collection: "revoked_tokens", Index: mongo.IndexModel{
+				Keys: bson.M{"seq": 1},
+			}},
+			IndexStep{Collection: "revoked_tokens", Index: mongo.IndexModel{
+				Keys: bson.M{"jti": 1},
+			}},
+			IndexStep{Collection: "revoked_tokens", Index: mongo.IndexModel{
+				Keys:    bson.M{"expires_at": 1},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			}},
+			IndexStep{Collection: "security_events", Index: mongo.IndexModel{
+				Keys:    bson.M{"seq": 1},
+				Options: options.Index().SetUnique(true),
+			}},
+			IndexStep{Collection: "security_events", Index: mongo.IndexModel{
+				Keys:    bson.M{"expires_at": 1},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			}},
+		},
+	},
+	{
+		Version:     2,
+		Description: "add session fields to existing tokens",
+		Steps: []Step{
+			// Tokens issued before sessions were listed have no creation
+			// time, take it from their ObjectID.
+			UpdateStep{
+				Collection: "tokens",
+				Filter:     bson.M{"created_at": bson.M{"$exists": false}},
+				Update: mongo.Pipeline{
+					{{Key: "$set", Value: bson.M{"created_at": bson.M{"$toDate": "$_id"}}}},
+				},
+			},
+		},
+	},
+}
@@ -0,0 +1,26 @@
+package password_test
+
+import (
+	"testing"
+
+	"github.com/flaambe/authservice/password"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	params := password.Params{Time: 1, Memory: 8 * 1024, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+	hash, err := password.Hash("correct horse", params)
+	require.NoError(t, err)
+
+	ok, err := password.Verify("correct horse", hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = password.Verify("battery staple", hash)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, err = password.Verify("correct horse", "not a hash")
+	require.Equal(t, password.ErrInvalidHash, err)
+}
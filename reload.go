@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flaambe/authservice/config"
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/tlsconf"
+	"github.com/flaambe/authservice/token"
+)
+
+// secretsTimeout bounds each poll of the secret provider.
+const secretsTimeout = 30 * time.Second
+
+// reloadable lists the configuration paths, or path prefixes ending with a
+// dot, that take effect without a restart.
+var reloadable = []string{"log_level", "tokens.", "secrets.", "tls."}
+
+// reloader applies configuration changes to the running service, on SIGHUP
+// and when the secret provider returns new secrets. Changes are validated as
+// a whole before any of them is applied.
+type reloader struct {
+	mu      sync.Mutex
+	args    []string
+	current *config.Config
+	signer  *token.Signer
+	// recordKeyRotation announces the kid of a new access secret.
+	recordKeyRotation func(kid string) error
+	// tlsServer is nil if TLS is disabled.
+	tlsServer *tlsconf.Server
+	// pending is the last configuration with changes that require a
+	// restart, so that they are only reported once.
+	pending *config.Config
+}
+
+func newReloader(args []string, cfg *config.Config, signer *token.Signer, recordKeyRotation func(kid string) error, tlsServer *tlsconf.Server) *reloader {
+	return &reloader{args: args, current: cfg, signer: signer, recordKeyRotation: recordKeyRotation, tlsServer: tlsServer}
+}
+
+// reload loads the configuration again from the file, environment and the
+// original command-line flags, then applies it if it is valid.
+func (r *reloader) reload() {
+	flags, _ := newServeFlags(flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	next, err := config.Load(flags, r.args)
+	if err == nil {
+		err = loadSecrets(next)
+	}
+
+	if err == nil {
+		err = next.Validate()
+	}
+
+	if err != nil {
+		slog.Error("Configuration reload rejected", "err", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.apply(next)
+}
+
+// refreshSecrets polls the secret provider for new secrets until ctx is done.
+func (r *reloader) refreshSecrets(ctx context.Context) {
+	for {
+		r.mu.Lock()
+		interval := r.current.Secrets.RefreshInterval
+		r.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		r.mu.Lock()
+		base := r.current
+		r.mu.Unlock()
+
+		next := *base
+		provider := next.SecretProvider()
+
+		if provider == nil {
+			continue
+		}
+
+		pollCtx, cancel := context.WithTimeout(ctx, secretsTimeout)
+		err := next.LoadSecrets(pollCtx, provider)
+		cancel()
+
+		if err != nil {
+			slog.Error("Refreshing secrets failed", "err", err)
+			continue
+		}
+
+		if err := next.Validate(); err != nil {
+			slog.Error("Refreshed secrets rejected", "err", err)
+			continue
+		}
+
+		r.mu.Lock()
+		// Skip secrets loaded for a configuration replaced meanwhile.
+		if r.current == base {
+			r.apply(&next)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// apply switches to next, which must be valid, and logs the changes. Changes
+// to settings that can not be reloaded are logged and ignored until restart.
+// r.mu must be held.
+func (r *reloader) apply(next *config.Config) {
+	changed := r.current.Changed(next)
+	if len(changed) == 0 {
+		r.pending = nil
+		return
+	}
+
+	// Listeners are created with or without TLS at startup.
+	tlsToggled := (next.TLS.CertFile == "") != (r.current.TLS.CertFile == "")
+
+	var applied, restart []string
+	for _, path := range changed {
+		if isReloadable(path) && !(tlsToggled && strings.HasPrefix(path, "tls.")) {
+			applied = append(applied, path)
+		} else {
+			restart = append(restart, path)
+		}
+	}
+
+	// Secrets are polled, so report each pending value once only.
+	unreported := restart
+	if r.pending != nil && len(restart) > 0 {
+		unreported = intersect(restart, r.pending.Changed(next))
+	}
+
+	if len(unreported) > 0 {
+		slog.Warn("Configuration changes require a restart", "paths", strings.Join(unreported, ","))
+	}
+
+	r.pending = nil
+	if len(restart) > 0 {
+		r.pending = next
+	}
+
+	if len(applied) == 0 {
+		return
+	}
+
+	// Load the certificate first, since it is the only change that can fail.
+	if r.tlsServer != nil && !tlsToggled && next.TLS != r.current.TLS {
+		if err := r.tlsServer.Reload(*next.TLSOptions()); err != nil {
+			slog.Error("Configuration reload rejected", "err", err)
+			return
+		}
+	}
+
+	if next.LogLevel != r.current.LogLevel {
+		logLevel.Set(next.SlogLevel())
+	}
+
+	if next.Tokens != r.current.Tokens {
+		r.signer.Update(next.TokenConfig())
+	}
+
+	// Announce the new access secret off the lock, since it writes to the
+	// database.
+	if next.Tokens.AccessSecret != r.current.Tokens.AccessSecret {
+		kid := r.signer.KeyID()
+		go func() {
+			var requestErr *errs.RequestError
+			if err := r.recordKeyRotation(kid); errors.As(err, &requestErr) {
+				slog.Error("Recording key rotation failed", "kid", kid, "err", requestErr.Err)
+			}
+		}()
+	}
+
+	// Keep the settings that were not applied, so that they keep differing
+	// from the loaded configuration until restart.
+	merged := *r.current
+	merged.LogLevel = next.LogLevel
+	merged.Tokens = next.Tokens
+	merged.Secrets = next.Secrets
+	if !tlsToggled {
+		merged.TLS = next.TLS
+	}
+	r.current = &merged
+
+	slog.Info("Configuration reloaded", "changed", strings.Join(applied, ","))
+}
+
+// intersect returns the elements of a that are also in b.
+func intersect(a, b []string) []string {
+	var both []string
+	for _, s := range a {
+		if slices.Contains(b, s) {
+			both = append(both, s)
+		}
+	}
+
+	return both
+}
+
+func isReloadable(path string) bool {
+	for _, p := range reloadable {
+		if path == p || strings.HasSuffix(p, ".") && strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+
+	return false
+}
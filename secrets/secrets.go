@@ -0,0 +1,162 @@
+// Package secrets looks up secrets such as signing keys outside of the
+// environment: in mounted files or in a Vault compatible key-value store.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by a Provider that has no secret with the name
+// requested.
+var ErrNotFound = errors.New("secret not found")
+
+// DefaultVaultTimeout bounds requests of a VaultProvider created without an
+// HTTP client.
+const DefaultVaultTimeout = 10 * time.Second
+
+// Provider looks up secrets by name.
+type Provider interface {
+	Secret(ctx context.Context, name string) ([]byte, error)
+}
+
+// Snapshotter is implemented by providers whose secrets can change between
+// lookups. Snapshot returns a Provider of the secrets as they are now, so that
+// secrets looked up together come from the same version.
+type Snapshotter interface {
+	Snapshot(ctx context.Context) (Provider, error)
+}
+
+// FileProvider reads each secret from the file named after it in a
+// directory, the layout of Docker and Kubernetes secret mounts.
+type FileProvider struct {
+	dir string
+}
+
+func NewFileProvider(dir string) *FileProvider {
+	return &FileProvider{dir: dir}
+}
+
+// Secret returns the content of the file without its trailing newline.
+func (p *FileProvider) Secret(ctx context.Context, name string) ([]byte, error) {
+	if name == "" || name != filepath.Base(name) {
+		return nil, fmt.Errorf("invalid secret name %q", name)
+	}
+
+	b, err := os.ReadFile(filepath.Join(p.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(b, "\r\n"), nil
+}
+
+// VaultProvider reads secrets from the fields of a single secret of a Vault
+// KV version 2 engine, or of any server implementing the same API.
+type VaultProvider struct {
+	url        string
+	token      string
+	httpClient *http.Client
+}
+
+// NewVaultProvider returns a provider for the secret at path of the KV
+// engine mounted at mount on the server at addr. A nil httpClient uses a
+// client with DefaultVaultTimeout.
+func NewVaultProvider(addr, token, mount, path string, httpClient *http.Client) *VaultProvider {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultVaultTimeout}
+	}
+
+	return &VaultProvider{
+		url:        strings.TrimRight(addr, "/") + "/v1/" + url.PathEscape(mount) + "/data/" + strings.Trim(path, "/"),
+		token:      token,
+		httpClient: httpClient,
+	}
+}
+
+// Secret returns the field name of the latest version of the secret.
+func (p *VaultProvider) Secret(ctx context.Context, name string) ([]byte, error) {
+	data, err := p.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return mapProvider(data).Secret(ctx, name)
+}
+
+// Snapshot reads the latest version of the secret once and returns a
+// provider of its fields.
+func (p *VaultProvider) Snapshot(ctx context.Context) (Provider, error) {
+	data, err := p.fetch(ctx)
+	if errors.Is(err, ErrNotFound) {
+		return mapProvider(nil), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return mapProvider(data), nil
+}
+
+// fetch returns the fields of the latest version of the secret.
+func (p *VaultProvider) fetch(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("X-Vault-Token", p.token)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault: unexpected status %s", resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+
+	return body.Data.Data, nil
+}
+
+// mapProvider holds the fields of a secret read by a VaultProvider.
+type mapProvider map[string]string
+
+func (m mapProvider) Secret(ctx context.Context, name string) ([]byte, error) {
+	value, ok := m[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return []byte(value), nil
+}
@@ -0,0 +1,82 @@
+package secrets_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flaambe/authservice/secrets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileProvider(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "access_secret"), []byte("from-file\n"), 0600))
+
+	p := secrets.NewFileProvider(dir)
+
+	secret, err := p.Secret(context.Background(), "access_secret")
+	require.NoError(t, err)
+	require.Equal(t, "from-file", string(secret))
+
+	_, err = p.Secret(context.Background(), "refresh_secret")
+	require.ErrorIs(t, err, secrets.ErrNotFound)
+
+	_, err = p.Secret(context.Background(), "../access_secret")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, secrets.ErrNotFound)
+}
+
+func TestVaultProvider(t *testing.T) {
+	var requests int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if r.URL.Path != "/v1/kv/data/authservice/prod" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Write([]byte(`{"data":{"data":{"access_secret":"from-vault","refresh_secret":"also-from-vault"},"metadata":{"version":3}}}`))
+	}))
+	defer srv.Close()
+
+	p := secrets.NewVaultProvider(srv.URL, "root", "kv", "/authservice/prod/", srv.Client())
+
+	secret, err := p.Secret(context.Background(), "access_secret")
+	require.NoError(t, err)
+	require.Equal(t, "from-vault", string(secret))
+
+	_, err = p.Secret(context.Background(), "admin_token")
+	require.ErrorIs(t, err, secrets.ErrNotFound)
+
+	// A snapshot reads the secret once for all fields
+	requests = 0
+
+	snapshot, err := p.Snapshot(context.Background())
+	require.NoError(t, err)
+
+	secret, err = snapshot.Secret(context.Background(), "refresh_secret")
+	require.NoError(t, err)
+	require.Equal(t, "also-from-vault", string(secret))
+
+	_, err = snapshot.Secret(context.Background(), "admin_token")
+	require.ErrorIs(t, err, secrets.ErrNotFound)
+	require.Equal(t, 1, requests)
+
+	_, err = secrets.NewVaultProvider(srv.URL, "root", "kv", "other", srv.Client()).Secret(context.Background(), "access_secret")
+	require.ErrorIs(t, err, secrets.ErrNotFound)
+
+	_, err = secrets.NewVaultProvider(srv.URL, "wrong", "kv", "authservice/prod", srv.Client()).Secret(context.Background(), "access_secret")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, secrets.ErrNotFound)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/flaambe/authservice/authpb"
+	"github.com/flaambe/authservice/config"
+	"github.com/flaambe/authservice/extauthz"
+	"github.com/flaambe/authservice/grpcapi"
+	"github.com/flaambe/authservice/handlers"
+	"github.com/flaambe/authservice/mailer"
+	"github.com/flaambe/authservice/tlsconf"
+	"github.com/flaambe/authservice/token"
+	"github.com/flaambe/authservice/usecase"
+	"github.com/flaambe/authservice/webauthn"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// newServeFlags returns the flags of serve besides the configuration flags.
+func newServeFlags(errorHandling flag.ErrorHandling) (flags *flag.FlagSet, ensureIndexes *bool) {
+	flags = flag.NewFlagSet("serve", errorHandling)
+	ensureIndexes = flags.Bool("ensure-indexes", true, "create missing indexes before serving")
+
+	return flags, ensureIndexes
+}
+
+// serve runs the HTTP and gRPC servers until SIGINT or SIGTERM, reloading the
+// configuration on SIGHUP.
+func serve(args []string) {
+	flags, ensureIndexes := newServeFlags(flag.ExitOnError)
+	cfg := loadConfig(flags, args)
+	logLevel.Set(cfg.SlogLevel())
+	fmt.Fprintf(os.Stderr, "Configuration:\n%s", cfg.Redacted())
+
+	dbConfig := openDB(cfg)
+
+	if *ensureIndexes {
+		if err := dbConfig.EnsureIndexes(); err != nil {
+			fatal("Creating indexes failed", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	defer dbConfig.DB.Client().Disconnect(ctx)
+
+	signer := token.NewSigner(cfg.TokenConfig())
+
+	var tlsServer *tlsconf.Server
+	if opts := cfg.TLSOptions(); opts != nil {
+		var err error
+		if tlsServer, err = tlsconf.NewServer(*opts); err != nil {
+			fatal("Loading TLS certificate failed", err)
+		}
+	}
+
+	authUsecase, err := usecase.NewAuthUsecase(dbConfig.DB, signer, getAuthOptions(cfg)...)
+	if err != nil {
+		fatal("Creating auth usecase failed", err)
+	}
+
+	reloader := newReloader(args, cfg, signer, authUsecase.RecordKeyRotation, tlsServer)
+
+	refreshCtx, stopRefresh := context.WithCancel(context.Background())
+	defer stopRefresh()
+
+	go reloader.refreshSecrets(refreshCtx)
+
+	if tlsServer != nil {
+		go tlsServer.Watch(refreshCtx, cfg.TLS.ReloadInterval)
+	}
+
+	authHandler := handlers.NewAuthHandler(authUsecase)
+	accountHandler := handlers.NewAccountHandler(authUsecase)
+	mfaHandler := handlers.NewMFAHandler(authUsecase)
+	passkeyHandler := handlers.NewPasskeyHandler(authUsecase)
+	sessionHandler := handlers.NewSessionHandler(authUsecase)
+	revocationHandler := handlers.NewRevocationHandler(authUsecase)
+	verifyHandler := handlers.NewVerifyHandler(authUsecase)
+
+	router := mux.NewRouter()
+	if cfg.TrustProxyHeaders {
+		router.Use(handlers.ProxyHeaders)
+	}
+
+	router.HandleFunc("/auth", authHandler.Auth).Methods("POST")
+	router.HandleFunc("/refreshToken", authHandler.RefreshToken).Methods("POST")
+	router.HandleFunc("/deleteToken", authHandler.DeleteToken).Methods("POST")
+	router.HandleFunc("/deleteAllTokens", authHandler.DeleteAllTokens).Methods("POST")
+	router.HandleFunc("/sessions", sessionHandler.ListSessions).Methods("GET")
+	router.HandleFunc("/sessions/{id}", sessionHandler.RevokeSession).Methods("DELETE")
+	router.HandleFunc("/revocations", revocationHandler.Revocations).Methods("GET")
+	router.HandleFunc("/verify", verifyHandler.Verify).Methods("GET")
+	router.HandleFunc("/register", accountHandler.Register).Methods("POST")
+	router.HandleFunc("/login", accountHandler.Login).Methods("POST")
+	router.HandleFunc("/changePassword", accountHandler.ChangePassword).Methods("POST")
+	router.HandleFunc("/password/forgot", accountHandler.ForgotPassword).Methods("POST")
+	router.HandleFunc("/password/reset", accountHandler.ResetPassword).Methods("POST")
+	router.HandleFunc("/email/verify/request", accountHandler.RequestEmailVerification).Methods("POST")
+	router.HandleFunc("/email/verify", accountHandler.VerifyEmail).Methods("POST")
+	router.HandleFunc("/login/mfa", mfaHandler.LoginMFA).Methods("POST")
+	router.HandleFunc("/login/email", accountHandler.RequestEmailLogin).Methods("POST")
+	router.HandleFunc("/login/email/redeem", accountHandler.RedeemEmailLogin).Methods("POST")
+	router.HandleFunc("/mfa/totp/enroll", mfaHandler.EnrollTOTP).Methods("POST")
+	router.HandleFunc("/mfa/totp/confirm", mfaHandler.ConfirmTOTP).Methods("POST")
+	router.HandleFunc("/passkeys/register/begin", passkeyHandler.BeginRegistration).Methods("POST")
+	router.HandleFunc("/passkeys/register/finish", passkeyHandler.FinishRegistration).Methods("POST")
+	router.HandleFunc("/passkeys/login/begin", passkeyHandler.BeginLogin).Methods("POST")
+	router.HandleFunc("/passkeys/login/finish", passkeyHandler.FinishLogin).Methods("POST")
+
+	// The admin API is served on the main listener unless ADMIN_ADDR sets a
+	// separate one.
+	var adminSrv *http.Server
+
+	adminToken, adminRole := string(cfg.Admin.Token), cfg.Admin.Role
+	if adminToken != "" || adminRole != "" {
+		adminHandler := handlers.NewAdminHandler(authUsecase)
+
+		adminParent := router
+		if addr := cfg.Admin.Addr; addr != "" {
+			adminParent = mux.NewRouter()
+			adminSrv = &http.Server{
+				Addr:         addr,
+				WriteTimeout: time.Second * 15,
+				ReadTimeout:  time.Second * 15,
+				IdleTimeout:  time.Second * 60,
+				Handler:      adminParent,
+			}
+		}
+
+		adminRouter := adminParent.PathPrefix("/admin").Subrouter()
+		adminRouter.Use(handlers.RequireAdmin(adminToken, adminRole, authUsecase))
+		adminRouter.HandleFunc("/users", adminHandler.CreateUser).Methods("POST")
+		adminRouter.HandleFunc("/users", adminHandler.SearchUsers).Methods("GET")
+		adminRouter.HandleFunc("/users/{guid}", adminHandler.GetUser).Methods("GET")
+		adminRouter.HandleFunc("/users/{guid}", adminHandler.DeleteUser).Methods("DELETE")
+		adminRouter.HandleFunc("/users/{guid}/sessions", adminHandler.ListUserSessions).Methods("GET")
+		adminRouter.HandleFunc("/users/{guid}/logout", adminHandler.LogoutUser).Methods("POST")
+		adminRouter.HandleFunc("/users/{guid}/disable", adminHandler.DisableUser).Methods("POST")
+		adminRouter.HandleFunc("/users/{guid}/enable", adminHandler.EnableUser).Methods("POST")
+		adminRouter.HandleFunc("/users/{guid}/grants", adminHandler.SetUserGrants).Methods("PUT")
+		adminRouter.HandleFunc("/stats", adminHandler.Stats).Methods("GET")
+	}
+
+	if eventsToken := string(cfg.EventsToken); eventsToken != "" {
+		eventHandler := handlers.NewEventHandler(authUsecase)
+
+		stream := handlers.RequireToken(eventsToken)(http.HandlerFunc(eventHandler.Stream))
+		router.Handle("/events", stream).Methods("GET")
+	}
+
+	srv := &http.Server{
+		Addr:         cfg.Addr(),
+		WriteTimeout: time.Second * 15,
+		ReadTimeout:  time.Second * 15,
+		IdleTimeout:  time.Second * 60,
+		Handler:      router,
+	}
+
+	go serveHTTP(srv, tlsServer)
+
+	if adminSrv != nil {
+		go serveHTTP(adminSrv, tlsServer)
+	}
+
+	var grpcServers []*grpc.Server
+
+	if addr := cfg.GRPCAddr; addr != "" {
+		grpcServers = append(grpcServers, serveGRPC(addr, tlsServer, func(s *grpc.Server) {
+			authpb.RegisterAuthServiceServer(s, grpcapi.NewServer(authUsecase, adminToken))
+		}))
+	}
+
+	if addr := cfg.ExtAuthzAddr; addr != "" {
+		grpcServers = append(grpcServers, serveGRPC(addr, tlsServer, func(s *grpc.Server) {
+			authv3.RegisterAuthorizationServer(s, extauthz.NewServer(authUsecase))
+		}))
+	}
+
+	// Reload the configuration on SIGHUP, without interrupting the servers.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			reloader.reload()
+		}
+	}()
+
+	// Create channel for shutdown signals.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	signal.Notify(stop, syscall.SIGTERM)
+
+	//Recieve shutdown signals.
+	<-stop
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	for _, s := range grpcServers {
+		s.GracefulStop()
+	}
+
+	if adminSrv != nil {
+		if err := adminSrv.Shutdown(ctx); err != nil {
+			slog.Error("Error shutting down admin server", "err", err)
+		}
+	}
+
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("Error shutting down server", "err", err)
+	} else {
+		slog.Info("Server gracefully stopped")
+	}
+}
+
+// serveHTTP runs listenAndServe until srv is shut down, exiting on any other
+// error.
+func serveHTTP(srv *http.Server, tlsServer *tlsconf.Server) {
+	if err := listenAndServe(srv, tlsServer); !errors.Is(err, http.ErrServerClosed) {
+		fatal("Server failed", err)
+	}
+}
+
+// listenAndServe serves srv over TLS when tlsServer is not nil.
+func listenAndServe(srv *http.Server, tlsServer *tlsconf.Server) error {
+	if tlsServer == nil {
+		return srv.ListenAndServe()
+	}
+
+	srv.TLSConfig = tlsServer.Config("h2", "http/1.1")
+
+	return srv.ListenAndServeTLS("", "")
+}
+
+// serveGRPC starts a gRPC server on addr with the services added by register,
+// over TLS when tlsServer is not nil.
+func serveGRPC(addr string, tlsServer *tlsconf.Server, register func(*grpc.Server)) *grpc.Server {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("Listening failed", err)
+	}
+
+	var opts []grpc.ServerOption
+	if tlsServer != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsServer.Config("h2"))))
+	}
+
+	s := grpc.NewServer(opts...)
+	register(s)
+
+	go func() {
+		// Serve returns nil or ErrServerStopped once GracefulStop is called.
+		if err := s.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			fatal("gRPC server failed", err)
+		}
+	}()
+
+	return s
+}
+
+func getAuthOptions(cfg *config.Config) []usecase.Option {
+	var opts []usecase.Option
+
+	if cfg.Auth.GUIDAuthDisabled {
+		opts = append(opts, usecase.WithGUIDAuthDisabled())
+	}
+
+	if cfg.Auth.AutoProvisionDisabled {
+		opts = append(opts, usecase.WithAutoProvisionDisabled())
+	}
+
+	if issuer := cfg.Auth.TOTPIssuer; issuer != "" {
+		opts = append(opts, usecase.WithTOTPIssuer(issuer))
+	}
+
+	if rpID := cfg.WebAuthn.RPID; rpID != "" {
+		opts = append(opts, usecase.WithRelyingParty(&webauthn.RelyingParty{
+			ID:     rpID,
+			Name:   cfg.WebAuthn.RPName,
+			Origin: cfg.WebAuthn.Origin,
+		}))
+	}
+
+	if m := getMailer(cfg); m != nil {
+		opts = append(opts, usecase.WithMailer(m))
+	}
+
+	if u := cfg.Mail.EmailLoginURL; u != "" {
+		opts = append(opts, usecase.WithEmailLoginURL(u))
+	}
+
+	if u := cfg.Mail.PasswordResetURL; u != "" {
+		opts = append(opts, usecase.WithPasswordResetURL(u))
+	}
+
+	if u := cfg.Mail.VerifyEmailURL; u != "" {
+		opts = append(opts, usecase.WithVerifyEmailURL(u))
+	}
+
+	if dir := cfg.Mail.TemplatesDir; dir != "" {
+		templates, err := mailer.LoadTemplates(dir)
+		if err != nil {
+			fatal("Loading mail templates failed", err)
+		}
+
+		opts = append(opts, usecase.WithMailTemplates(templates))
+	}
+
+	limits := usecase.SessionLimits{
+		MaxPerUser:   cfg.Sessions.MaxPerUser,
+		MaxPerClient: cfg.Sessions.MaxPerClient,
+	}
+
+	if cfg.Sessions.LimitPolicy == "evict_oldest" {
+		limits.Action = usecase.EvictOldestSession
+	}
+
+	opts = append(opts, usecase.WithSessionLimits(limits))
+	opts = append(opts, usecase.WithPasswordParams(cfg.PasswordParams()))
+
+	return opts
+}
+
+func getMailer(cfg *config.Config) mailer.Mailer {
+	switch {
+	case cfg.Mail.SMTPAddr != "":
+		return mailer.NewSMTPMailer(cfg.Mail.SMTPAddr, cfg.Mail.From,
+			cfg.Mail.SMTPUsername, string(cfg.Mail.SMTPPassword))
+	case cfg.Mail.Dir != "":
+		return mailer.NewFileMailer(cfg.Mail.Dir, cfg.Mail.From)
+	default:
+		return nil
+	}
+}
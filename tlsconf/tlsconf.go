@@ -0,0 +1,238 @@
+// Package tlsconf serves TLS with a certificate, client CA bundle and
+// protocol settings that can be replaced while listeners keep running.
+package tlsconf
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options are the TLS settings of the servers.
+type Options struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	ClientAuth   tls.ClientAuthType
+	MinVersion   uint16
+	CipherSuites []uint16
+}
+
+// Server holds the current TLS settings of the servers.
+type Server struct {
+	mu       sync.Mutex
+	current  atomic.Pointer[settings]
+	modTimes []time.Time
+}
+
+type settings struct {
+	options     Options
+	certificate tls.Certificate
+	clientCAs   *x509.CertPool
+}
+
+// NewServer loads the certificate and client CA bundle of opts.
+func NewServer(opts Options) (*Server, error) {
+	s := &Server{}
+	if err := s.Reload(opts); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Reload loads the files of opts and switches to them for new connections.
+// On error the current settings are kept.
+func (s *Server) Reload(opts Options) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.reload(opts)
+}
+
+func (s *Server) reload(opts Options) error {
+	modTimes := s.fileModTimes(opts)
+
+	next, err := load(opts)
+	if err != nil {
+		return err
+	}
+
+	s.current.Store(next)
+	s.modTimes = modTimes
+
+	return nil
+}
+
+// Watch reloads the files when they are modified, checking every interval
+// until ctx is done. Files replaced with an invalid certificate are logged
+// and ignored.
+func (s *Server) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+
+		opts := s.current.Load().options
+		if modTimes := s.fileModTimes(opts); !equalTimes(modTimes, s.modTimes) {
+			if err := s.reload(opts); err != nil {
+				slog.Error("TLS certificate reload failed", "err", err)
+				s.modTimes = modTimes
+			} else {
+				slog.Info("TLS certificate reloaded", "cert_file", opts.CertFile)
+			}
+		}
+
+		s.mu.Unlock()
+	}
+}
+
+// Config returns the configuration of a server negotiating nextProtos, that
+// uses the current settings for every new connection.
+func (s *Server) Config(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		NextProtos: nextProtos,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &s.current.Load().certificate, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			current := s.current.Load()
+
+			return &tls.Config{
+				Certificates: []tls.Certificate{current.certificate},
+				ClientCAs:    current.clientCAs,
+				ClientAuth:   current.options.ClientAuth,
+				MinVersion:   current.options.MinVersion,
+				CipherSuites: current.options.CipherSuites,
+				NextProtos:   nextProtos,
+			}, nil
+		},
+	}
+}
+
+func load(opts Options) (*settings, error) {
+	certificate, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	next := &settings{options: opts, certificate: certificate}
+
+	if opts.ClientCAFile != "" {
+		b, err := os.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		next.clientCAs = x509.NewCertPool()
+		if !next.clientCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("%s: no certificates found", opts.ClientCAFile)
+		}
+	}
+
+	return next, nil
+}
+
+// fileModTimes returns the modification times of the files of opts, zero for
+// files that can not be read.
+func (s *Server) fileModTimes(opts Options) []time.Time {
+	var modTimes []time.Time
+
+	for _, name := range []string{opts.CertFile, opts.KeyFile, opts.ClientCAFile} {
+		var modTime time.Time
+		if info, err := os.Stat(name); err == nil {
+			modTime = info.ModTime()
+		}
+
+		modTimes = append(modTimes, modTime)
+	}
+
+	return modTimes
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseVersion parses a TLS version such as 1.2.
+func ParseVersion(s string) (uint16, error) {
+	switch s {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+
+	return 0, fmt.Errorf("unsupported TLS version %q", s)
+}
+
+// ParseCipherSuites parses a comma separated list of cipher suite names, as
+// returned by tls.CipherSuiteName. Insecure suites are rejected. An empty
+// list selects the Go defaults.
+func ParseCipherSuites(s string) ([]uint16, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	ids := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		ids[suite.Name] = suite.ID
+	}
+
+	var suites []uint16
+	var problems []error
+
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+
+		id, ok := ids[name]
+		if !ok {
+			problems = append(problems, fmt.Errorf("unsupported cipher suite %q", name))
+			continue
+		}
+
+		suites = append(suites, id)
+	}
+
+	return suites, errors.Join(problems...)
+}
+
+// ParseClientAuth parses a client certificate policy: none, request to verify
+// certificates that clients present, or require.
+func ParseClientAuth(s string) (tls.ClientAuthType, error) {
+	switch s {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+
+	return 0, fmt.Errorf("unsupported client auth %q", s)
+}
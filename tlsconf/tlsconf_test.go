@@ -0,0 +1,156 @@
+package tlsconf_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flaambe/authservice/tlsconf"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate writes a self-signed certificate for localhost and its key
+// to dir, returning the file names and the certificate.
+func writeCertificate(t *testing.T, dir, name string) (certFile, keyFile string, cert *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certFile, keyFile, cert
+}
+
+// handshake connects to a listener using config and returns the certificate
+// presented by the server.
+func handshake(t *testing.T, config *tls.Config, client *tls.Config) (*x509.Certificate, error) {
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	require.NoError(t, err)
+	defer lis.Close()
+
+	go func() {
+		conn, err := lis.Accept()
+		if err == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	conn, err := tls.Dial("tcp", lis.Addr().String(), client)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, first := writeCertificate(t, dir, "first")
+
+	server, err := tlsconf.NewServer(tlsconf.Options{CertFile: certFile, KeyFile: keyFile, MinVersion: tls.VersionTLS12})
+	require.NoError(t, err)
+
+	config := server.Config("http/1.1")
+	client := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}}
+
+	presented, err := handshake(t, config, client)
+	require.NoError(t, err)
+	require.Equal(t, first.Raw, presented.Raw)
+
+	// New connections use the replaced certificate
+	certFile, keyFile, second := writeCertificate(t, dir, "second")
+	require.NoError(t, server.Reload(tlsconf.Options{CertFile: certFile, KeyFile: keyFile, MinVersion: tls.VersionTLS13}))
+
+	presented, err = handshake(t, config, client)
+	require.NoError(t, err)
+	require.Equal(t, second.Raw, presented.Raw)
+
+	_, err = handshake(t, config, &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	require.Error(t, err)
+
+	// An invalid certificate is rejected and the current one kept
+	require.NoError(t, os.WriteFile(certFile, []byte("invalid"), 0600))
+	require.Error(t, server.Reload(tlsconf.Options{CertFile: certFile, KeyFile: keyFile}))
+
+	presented, err = handshake(t, config, client)
+	require.NoError(t, err)
+	require.Equal(t, second.Raw, presented.Raw)
+}
+
+func TestClientAuth(t *testing.T) {
+	certFile, keyFile, _ := writeCertificate(t, t.TempDir(), "server")
+	caFile, caKeyFile, _ := writeCertificate(t, t.TempDir(), "client")
+
+	server, err := tlsconf.NewServer(tlsconf.Options{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: caFile,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	require.NoError(t, err)
+
+	clientCert, err := tls.LoadX509KeyPair(caFile, caKeyFile)
+	require.NoError(t, err)
+
+	_, err = handshake(t, server.Config(), &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{clientCert}})
+	require.NoError(t, err)
+
+	// TLS 1.3 reports a missing client certificate after the handshake
+	// completes on the client side, so force TLS 1.2.
+	_, err = handshake(t, server.Config(), &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	require.Error(t, err)
+}
+
+func TestParse(t *testing.T) {
+	version, err := tlsconf.ParseVersion("1.3")
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS13), version)
+
+	_, err = tlsconf.ParseVersion("1.0")
+	require.Error(t, err)
+
+	suites, err := tlsconf.ParseCipherSuites("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+	require.NoError(t, err)
+	require.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, suites)
+
+	_, err = tlsconf.ParseCipherSuites("TLS_RSA_WITH_RC4_128_SHA")
+	require.Error(t, err)
+
+	clientAuth, err := tlsconf.ParseClientAuth("request")
+	require.NoError(t, err)
+	require.Equal(t, tls.VerifyClientCertIfGiven, clientAuth)
+}
@@ -1,7 +1,15 @@
 package token
 
 import (
-	"os"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -9,17 +17,137 @@ import (
 )
 
 const (
-	AccessTokenDuration  time.Duration = 10
-	RefreshTokenDuration time.Duration = 60
+	DefaultAccessTTL  = 10 * time.Minute
+	DefaultRefreshTTL = 60 * time.Minute
+	DefaultMFATTL     = 5 * time.Minute
 )
 
-func CreateAccessToken(userGUID string) (string, error) {
+var ErrInvalidToken = errors.New("token is invalid")
+
+// AccessClaims are the claims carried by an access token.
+type AccessClaims struct {
+	UserGUID string
+	// JTI identifies the token so that it can be revoked.
+	JTI string
+	// AMR lists the authentication methods used to obtain the token.
+	AMR    []string
+	Roles  []string
+	Scopes []string
+	// CertThumbprint binds the token to the client certificate with this
+	// thumbprint, as returned by CertThumbprint. Empty for bearer tokens.
+	CertThumbprint string
+
+	ExpiresAt time.Time
+}
+
+// Config holds the secrets tokens are signed with and their lifetimes.
+type Config struct {
+	AccessSecret  []byte
+	RefreshSecret []byte
+
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	MFATTL     time.Duration
+}
+
+// Signer creates and verifies the tokens issued by the service. Its
+// configuration can be updated while it is in use.
+type Signer struct {
+	mu     sync.RWMutex
+	config Config
+
+	// previousSecret still verifies tokens signed before the access secret
+	// changed, until previousUntil.
+	previousSecret []byte
+	previousUntil  time.Time
+}
+
+// NewSigner returns a Signer for config. Zero lifetimes are replaced with the
+// defaults.
+func NewSigner(config Config) *Signer {
+	return &Signer{config: withDefaults(config)}
+}
+
+// Update replaces the configuration. When the access secret changes, tokens
+// signed with the previous one are accepted until they expire.
+func (s *Signer) Update(config Config) {
+	config = withDefaults(config)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !bytes.Equal(config.AccessSecret, s.config.AccessSecret) {
+		s.previousSecret = s.config.AccessSecret
+		s.previousUntil = time.Now().Add(s.config.AccessTTL)
+	}
+
+	s.config = config
+}
+
+func (s *Signer) AccessTTL() time.Duration {
+	return s.current().AccessTTL
+}
+
+func (s *Signer) RefreshTTL() time.Duration {
+	return s.current().RefreshTTL
+}
+
+func (s *Signer) MFATTL() time.Duration {
+	return s.current().MFATTL
+}
+
+// KeyID returns the identifier of the current access secret, which access
+// tokens carry in their kid header.
+func (s *Signer) KeyID() string {
+	return KeyID(s.current().AccessSecret)
+}
+
+func (s *Signer) current() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.config
+}
+
+// accessSecrets returns the secrets tokens signed with the access secret are
+// verified with, the current one first.
+func (s *Signer) accessSecrets() [][]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	secrets := [][]byte{s.config.AccessSecret}
+	if s.previousSecret != nil && time.Now().Before(s.previousUntil) {
+		secrets = append(secrets, s.previousSecret)
+	}
+
+	return secrets
+}
+
+// CreateAccessToken signs an access token with claims. ExpiresAt is set from
+// the access token lifetime and empty lists are omitted.
+func (s *Signer) CreateAccessToken(claims AccessClaims) (string, error) {
+	config := s.current()
+
 	atClaims := jwt.MapClaims{}
-	atClaims["user_id"] = userGUID
-	atClaims["exp"] = time.Now().Add(time.Minute * AccessTokenDuration).Unix()
+	atClaims["user_id"] = claims.UserGUID
+	atClaims["jti"] = claims.JTI
+	atClaims["exp"] = time.Now().Add(config.AccessTTL).Unix()
+	if len(claims.AMR) > 0 {
+		atClaims["amr"] = claims.AMR
+	}
+	if len(claims.Roles) > 0 {
+		atClaims["roles"] = claims.Roles
+	}
+	if len(claims.Scopes) > 0 {
+		atClaims["scope"] = strings.Join(claims.Scopes, " ")
+	}
+	if claims.CertThumbprint != "" {
+		atClaims["cnf"] = map[string]string{"x5t#S256": claims.CertThumbprint}
+	}
 	at := jwt.NewWithClaims(jwt.SigningMethodHS512, atClaims)
+	at.Header["kid"] = KeyID(config.AccessSecret)
 
-	token, err := at.SignedString([]byte(os.Getenv("ACCESS_SECRET")))
+	token, err := at.SignedString(config.AccessSecret)
 	if err != nil {
 		return "", err
 	}
@@ -27,13 +155,93 @@ func CreateAccessToken(userGUID string) (string, error) {
 	return token, nil
 }
 
-func CreateRefreshToken(userGUID string) (string, error) {
+// ParseAccessToken verifies the signature and expiry of an access token and
+// returns its claims.
+func (s *Signer) ParseAccessToken(accessToken string) (AccessClaims, error) {
+	var claims AccessClaims
+	var err error
+
+	for _, secret := range s.accessSecrets() {
+		claims, err = ParseAccessTokenWithKeyfunc(accessToken, hmacKeyfunc(secret))
+		if err == nil {
+			break
+		}
+	}
+
+	return claims, err
+}
+
+// ParseAccessTokenWithKeyfunc is like ParseAccessToken but looks up the
+// verification key with keyFunc, which must also check the signing method.
+func ParseAccessTokenWithKeyfunc(accessToken string, keyFunc jwt.Keyfunc) (AccessClaims, error) {
+	var claims AccessClaims
+
+	at, err := jwt.Parse(accessToken, keyFunc)
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+
+	// MFA and purpose tokens share the signing key but carry a typ claim.
+	mapClaims, ok := at.Claims.(jwt.MapClaims)
+	if !ok || mapClaims["typ"] != nil {
+		return claims, ErrInvalidToken
+	}
+
+	claims.UserGUID, _ = mapClaims["user_id"].(string)
+	claims.JTI, _ = mapClaims["jti"].(string)
+	if claims.UserGUID == "" || claims.JTI == "" {
+		return claims, ErrInvalidToken
+	}
+
+	exp, ok := mapClaims["exp"].(float64)
+	if !ok {
+		return claims, ErrInvalidToken
+	}
+
+	claims.ExpiresAt = time.Unix(int64(exp), 0)
+	claims.AMR = stringList(mapClaims["amr"])
+	claims.Roles = stringList(mapClaims["roles"])
+	if scope, ok := mapClaims["scope"].(string); ok {
+		claims.Scopes = strings.Fields(scope)
+	}
+	if cnf, ok := mapClaims["cnf"].(map[string]interface{}); ok {
+		claims.CertThumbprint, _ = cnf["x5t#S256"].(string)
+	}
+
+	return claims, nil
+}
+
+func (s *Signer) CreateRefreshToken(userGUID string) (string, error) {
+	config := s.current()
+
 	atClaims := jwt.MapClaims{}
 	atClaims["user_id"] = userGUID
-	atClaims["exp"] = time.Now().Add(time.Minute * RefreshTokenDuration).Unix()
+	atClaims["exp"] = time.Now().Add(config.RefreshTTL).Unix()
 	at := jwt.NewWithClaims(jwt.SigningMethodHS256, atClaims)
 
-	token, err := at.SignedString([]byte((os.Getenv("REFRESH_SECRET"))))
+	token, err := at.SignedString(config.RefreshSecret)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// CreateMFAToken signs the short-lived challenge token returned by the first
+// login step of a user with multi-factor authentication enabled. jti
+// identifies the token so that it can be made single-use by the caller.
+func (s *Signer) CreateMFAToken(userGUID, jti string, amr []string) (string, error) {
+	config := s.current()
+
+	atClaims := jwt.MapClaims{}
+	atClaims["user_id"] = userGUID
+	atClaims["typ"] = "mfa"
+	atClaims["jti"] = jti
+	atClaims["amr"] = amr
+	atClaims["exp"] = time.Now().Add(config.MFATTL).Unix()
+	at := jwt.NewWithClaims(jwt.SigningMethodHS512, atClaims)
+
+	token, err := at.SignedString(config.AccessSecret)
 	if err != nil {
 		return "", err
 	}
@@ -41,6 +249,78 @@ func CreateRefreshToken(userGUID string) (string, error) {
 	return token, nil
 }
 
+// ParseMFAToken verifies an MFA challenge token and returns the user GUID, the
+// jti and the authentication methods already completed.
+func (s *Signer) ParseMFAToken(mfaToken string) (string, string, []string, error) {
+	claims, err := s.parse(mfaToken)
+	if err != nil || claims["typ"] != "mfa" {
+		return "", "", nil, ErrInvalidToken
+	}
+
+	userGUID, _ := claims["user_id"].(string)
+	jti, _ := claims["jti"].(string)
+
+	if userGUID == "" || jti == "" {
+		return "", "", nil, ErrInvalidToken
+	}
+
+	return userGUID, jti, stringList(claims["amr"]), nil
+}
+
+// CreatePurposeToken signs a token that lets the holder perform a single
+// action, such as a password reset, for userGUID within ttl. jti identifies
+// the token so that it can be made single-use by the caller.
+func (s *Signer) CreatePurposeToken(purpose, userGUID, jti string, ttl time.Duration) (string, error) {
+	atClaims := jwt.MapClaims{}
+	atClaims["user_id"] = userGUID
+	atClaims["typ"] = purpose
+	atClaims["jti"] = jti
+	atClaims["exp"] = time.Now().Add(ttl).Unix()
+	at := jwt.NewWithClaims(jwt.SigningMethodHS512, atClaims)
+
+	token, err := at.SignedString(s.current().AccessSecret)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ParsePurposeToken verifies a token created by CreatePurposeToken for
+// purpose and returns its user GUID and jti.
+func (s *Signer) ParsePurposeToken(purpose, purposeToken string) (string, string, error) {
+	claims, err := s.parse(purposeToken)
+	if err != nil || claims["typ"] != purpose {
+		return "", "", ErrInvalidToken
+	}
+
+	userGUID, _ := claims["user_id"].(string)
+	jti, _ := claims["jti"].(string)
+
+	if userGUID == "" || jti == "" {
+		return "", "", ErrInvalidToken
+	}
+
+	return userGUID, jti, nil
+}
+
+// parse verifies a token signed with the access secret and returns its
+// claims.
+func (s *Signer) parse(tokenString string) (jwt.MapClaims, error) {
+	for _, secret := range s.accessSecrets() {
+		at, err := jwt.Parse(tokenString, hmacKeyfunc(secret))
+		if err != nil {
+			continue
+		}
+
+		if claims, ok := at.Claims.(jwt.MapClaims); ok {
+			return claims, nil
+		}
+	}
+
+	return nil, ErrInvalidToken
+}
+
 func HashToken(token string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(token), 14)
 	if err != nil {
@@ -55,3 +335,96 @@ func CheckTokenHash(token, hash string) bool {
 
 	return err == nil
 }
+
+// HashSecret returns the hex encoded SHA-256 of a high-entropy random secret
+// such as a recovery code. Unlike HashToken it is deterministic, so the result
+// can be used to look the secret up.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// KeyID returns the identifier of a signing secret: the hex encoded first 8
+// bytes of its SHA-256. It does not reveal the secret.
+func KeyID(secret []byte) string {
+	sum := sha256.Sum256(secret)
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// HashCode returns the hex encoded HMAC-SHA256 of a short code, such as an
+// emailed login code, keyed with the access secret. Unlike HashSecret the
+// result can not be brute forced without the key.
+func (s *Signer) HashCode(code string) string {
+	return hashCode(s.current().AccessSecret, code)
+}
+
+// CheckCodeHash reports whether hash was returned by HashCode for code with
+// the current or previous access secret.
+func (s *Signer) CheckCodeHash(code, hash string) bool {
+	for _, secret := range s.accessSecrets() {
+		if hmac.Equal([]byte(hashCode(secret, code)), []byte(hash)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hashCode(secret []byte, code string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(code))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// stringList converts a JSON array claim to a string slice, skipping values
+// that are not strings.
+func stringList(claim interface{}) []string {
+	var list []string
+
+	if values, ok := claim.([]interface{}); ok {
+		for _, v := range values {
+			if s, ok := v.(string); ok {
+				list = append(list, s)
+			}
+		}
+	}
+
+	return list
+}
+
+func hmacKeyfunc(secret []byte) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS512 {
+			return nil, ErrInvalidToken
+		}
+
+		return secret, nil
+	}
+}
+
+func withDefaults(config Config) Config {
+	if config.AccessTTL == 0 {
+		config.AccessTTL = DefaultAccessTTL
+	}
+
+	if config.RefreshTTL == 0 {
+		config.RefreshTTL = DefaultRefreshTTL
+	}
+
+	if config.MFATTL == 0 {
+		config.MFATTL = DefaultMFATTL
+	}
+
+	return config
+}
+
+// CertThumbprint returns the SHA-256 thumbprint of a certificate that access
+// tokens are bound to, as in RFC 8705.
+func CertThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
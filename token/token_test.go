@@ -0,0 +1,131 @@
+package token_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/flaambe/authservice/token"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAccessToken(t *testing.T) {
+	signer := token.NewSigner(token.Config{
+		AccessSecret:  []byte("access-secret-used-by-the-token-tests"),
+		RefreshSecret: []byte("refresh-secret-used-by-the-token-tests"),
+	})
+
+	accessToken, err := signer.CreateAccessToken(token.AccessClaims{
+		UserGUID: "4aa32cc5-d0e6-49e7-897d-d2b26748b7d3",
+		JTI:      "0b6c1f0e-3a54-4c1e-8d7e-2f5a9c3b1d40",
+		AMR:      []string{"pwd", "otp"},
+		Scopes:   []string{"reports:read", "reports:write"},
+	})
+	require.NoError(t, err)
+
+	claims, err := signer.ParseAccessToken(accessToken)
+	require.NoError(t, err)
+	require.Equal(t, "4aa32cc5-d0e6-49e7-897d-d2b26748b7d3", claims.UserGUID)
+	require.Equal(t, []string{"pwd", "otp"}, claims.AMR)
+	require.Equal(t, []string{"reports:read", "reports:write"}, claims.Scopes)
+	require.Nil(t, claims.Roles)
+	require.Empty(t, claims.CertThumbprint)
+
+	// Certificate-bound tokens carry the thumbprint in the cnf claim
+	boundToken, err := signer.CreateAccessToken(token.AccessClaims{
+		UserGUID:       "4aa32cc5-d0e6-49e7-897d-d2b26748b7d3",
+		JTI:            "6f1d2c3b-4a5e-4f60-9b7a-8c9d0e1f2a3b",
+		CertThumbprint: "bwcK0esc3ACC3DB2Y5_lESsXE8o9ltc05O89jdN-dg2",
+	})
+	require.NoError(t, err)
+
+	claims, err = signer.ParseAccessToken(boundToken)
+	require.NoError(t, err)
+	require.Equal(t, "bwcK0esc3ACC3DB2Y5_lESsXE8o9ltc05O89jdN-dg2", claims.CertThumbprint)
+
+	// MFA challenge tokens are signed with the same key
+	mfaToken, err := signer.CreateMFAToken("4aa32cc5-d0e6-49e7-897d-d2b26748b7d3", "5d2c8e1a-7b3f-4e6d-9a0c-1f2e3d4c5b6a", []string{"pwd"})
+	require.NoError(t, err)
+
+	_, err = signer.ParseAccessToken(mfaToken)
+	require.Equal(t, token.ErrInvalidToken, err)
+
+	// Tokens signed with another secret are rejected
+	other := token.NewSigner(token.Config{AccessSecret: []byte("another-access-secret-for-the-token-tests")})
+
+	_, err = other.ParseAccessToken(accessToken)
+	require.Equal(t, token.ErrInvalidToken, err)
+}
+
+func TestSignerUpdate(t *testing.T) {
+	config := token.Config{
+		AccessSecret:  []byte("access-secret-used-by-the-token-tests"),
+		RefreshSecret: []byte("refresh-secret-used-by-the-token-tests"),
+	}
+	signer := token.NewSigner(config)
+
+	claims := token.AccessClaims{UserGUID: "4aa32cc5-d0e6-49e7-897d-d2b26748b7d3", JTI: "0b6c1f0e-3a54-4c1e-8d7e-2f5a9c3b1d40"}
+
+	oldToken, err := signer.CreateAccessToken(claims)
+	require.NoError(t, err)
+
+	config.AccessSecret = []byte("rotated-access-secret-for-the-token-tests")
+	signer.Update(config)
+
+	newToken, err := signer.CreateAccessToken(claims)
+	require.NoError(t, err)
+
+	// Access tokens name the secret they are signed with
+	require.Equal(t, token.KeyID(config.AccessSecret), signer.KeyID())
+	require.Equal(t, signer.KeyID(), keyID(t, newToken))
+	require.NotEqual(t, keyID(t, oldToken), keyID(t, newToken))
+
+	// Tokens signed before the rotation stay valid until they expire
+	_, err = signer.ParseAccessToken(oldToken)
+	require.NoError(t, err)
+
+	_, err = signer.ParseAccessToken(newToken)
+	require.NoError(t, err)
+
+	// Only the current secret verifies tokens after a second rotation
+	config.AccessSecret = []byte("another-rotated-access-secret-for-the-tests")
+	signer.Update(config)
+
+	_, err = signer.ParseAccessToken(oldToken)
+	require.Equal(t, token.ErrInvalidToken, err)
+
+	_, err = signer.ParseAccessToken(newToken)
+	require.NoError(t, err)
+}
+
+func keyID(t *testing.T, accessToken string) string {
+	header, err := base64.RawURLEncoding.DecodeString(strings.Split(accessToken, ".")[0])
+	require.NoError(t, err)
+
+	var fields struct {
+		KeyID string `json:"kid"`
+	}
+	require.NoError(t, json.Unmarshal(header, &fields))
+
+	return fields.KeyID
+}
+
+func TestCheckCodeHash(t *testing.T) {
+	config := token.Config{
+		AccessSecret:  []byte("access-secret-used-by-the-token-tests"),
+		RefreshSecret: []byte("refresh-secret-used-by-the-token-tests"),
+	}
+	signer := token.NewSigner(config)
+
+	hash := signer.HashCode("123456")
+	require.NotEqual(t, token.HashSecret("123456"), hash)
+	require.True(t, signer.CheckCodeHash("123456", hash))
+	require.False(t, signer.CheckCodeHash("654321", hash))
+
+	// Codes hashed before a rotation still verify
+	config.AccessSecret = []byte("rotated-access-secret-for-the-token-tests")
+	signer.Update(config)
+
+	require.True(t, signer.CheckCodeHash("123456", hash))
+}
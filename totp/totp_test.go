@@ -0,0 +1,48 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/flaambe/authservice/totp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B test vectors truncated to six digits
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, expected := range vectors {
+		code, err := totp.Code(secret, totp.Step(time.Unix(unix, 0)))
+		require.NoError(t, err)
+		require.Equal(t, expected, code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	code, err := totp.Code(secret, totp.Step(now.Add(-totp.Period)))
+	require.NoError(t, err)
+
+	step, ok := totp.Validate(secret, code, now)
+	require.True(t, ok)
+	require.Equal(t, totp.Step(now)-1, step)
+
+	code, err = totp.Code(secret, totp.Step(now.Add(-3*totp.Period)))
+	require.NoError(t, err)
+
+	_, ok = totp.Validate(secret, code, now)
+	require.False(t, ok)
+}
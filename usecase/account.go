@@ -0,0 +1,253 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/models"
+	"github.com/flaambe/authservice/password"
+	"github.com/flaambe/authservice/views"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+const minPasswordLength = 8
+
+func (a *AuthUsecase) Register(username, email, pass string) (views.RegisterResponse, error) {
+	var registerResponse views.RegisterResponse
+
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return registerResponse, errs.New(http.StatusBadRequest, "username is missing", nil)
+	}
+
+	// Logins containing @ are looked up by email only, so a username can not
+	// shadow the email of another account.
+	if strings.Contains(username, "@") {
+		return registerResponse, errs.New(http.StatusBadRequest, "username must not contain @", nil)
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return registerResponse, errs.New(http.StatusBadRequest, "email is invalid", err)
+	}
+
+	if len(pass) < minPasswordLength {
+		return registerResponse, errs.New(http.StatusBadRequest, "password is too short", nil)
+	}
+
+	passwordHash, err := password.Hash(pass, a.passwordParams)
+	if err != nil {
+		return registerResponse, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	user := models.User{
+		GUID:         uuid.New().String(),
+		Username:     username,
+		Email:        strings.ToLower(address.Address),
+		PasswordHash: passwordHash,
+	}
+
+	result, err := a.db.Collection("users").InsertOne(context.Background(), user)
+	if err != nil {
+		if isDuplicateKeyError(err) {
+			return registerResponse, errs.New(http.StatusConflict, "username or email already taken", err)
+		}
+
+		return registerResponse, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	user.ID = result.InsertedID.(primitive.ObjectID)
+
+	// The account is usable without a verified address, so the mail is sent
+	// in the background and a delivery failure is only logged.
+	if a.mailer != nil {
+		a.inBackground(func() error { return a.sendVerificationEmail(user) })
+	}
+
+	registerResponse = views.RegisterResponse{
+		GUID:     user.GUID,
+		Username: user.Username,
+		Email:    user.Email,
+	}
+
+	return registerResponse, nil
+}
+
+func (a *AuthUsecase) Login(login, pass string, client views.ClientInfo) (views.LoginResponse, error) {
+	account, err := a.loginAccount(login)
+	if err != nil {
+		return views.LoginResponse{}, err
+	}
+
+	keys := a.throttleKeys(client, account)
+	reserved, err := a.reserveAttempt(keys)
+	if err != nil {
+		return views.LoginResponse{}, err
+	}
+
+	loginResponse, err := a.login(login, pass, client)
+	a.recordAttempt(reserved, err)
+
+	return loginResponse, err
+}
+
+// loginFilter matches the user with login, which is an email when it contains
+// @ and a username otherwise.
+func loginFilter(login string) bson.M {
+	if strings.Contains(login, "@") {
+		return bson.M{"email": strings.ToLower(strings.TrimSpace(login))}
+	}
+
+	return bson.M{"username": strings.TrimSpace(login)}
+}
+
+func (a *AuthUsecase) login(login, pass string, client views.ClientInfo) (views.LoginResponse, error) {
+	var loginResponse views.LoginResponse
+
+	users := a.db.Collection("users")
+
+	err := a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		userValue := models.User{}
+
+		err = users.FindOne(sctx, loginFilter(login)).Decode(&userValue)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		if userValue.PasswordHash == "" {
+			_, _ = password.Verify(pass, a.dummyHash)
+			return errs.New(http.StatusUnauthorized, "invalid credentials", nil)
+		}
+
+		ok, err := password.Verify(pass, userValue.PasswordHash)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		if !ok {
+			return errs.New(http.StatusUnauthorized, "invalid credentials", nil)
+		}
+
+		if userValue.Disabled {
+			return errs.New(http.StatusForbidden, "user is disabled", nil)
+		}
+
+		if userValue.TOTPEnabled {
+			challenge, err := a.newMFAChallenge(userValue, []string{"pwd"})
+			if err != nil {
+				return err
+			}
+
+			loginResponse.MFAChallengeResponse = &challenge
+
+			return nil
+		}
+
+		authResponse, err := a.issueTokens(sctx, userValue, []string{"pwd"}, client)
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return err
+		}
+
+		loginResponse.AuthResponse = &authResponse
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		return nil
+	})
+
+	return loginResponse, err
+}
+
+func (a *AuthUsecase) ChangePassword(accessToken, oldPassword, newPassword string, client views.ClientInfo) error {
+	if len(newPassword) < minPasswordLength {
+		return errs.New(http.StatusBadRequest, "password is too short", nil)
+	}
+
+	users := a.db.Collection("users")
+
+	err := a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		_, userValue, err := a.authenticate(sctx, accessToken, client)
+		if err != nil {
+			return err
+		}
+
+		if userValue.PasswordHash == "" {
+			return errs.New(http.StatusBadRequest, "account has no password", nil)
+		}
+
+		ok, err := password.Verify(oldPassword, userValue.PasswordHash)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		if !ok {
+			return errs.New(http.StatusForbidden, "invalid credentials", nil)
+		}
+
+		passwordHash, err := password.Hash(newPassword, a.passwordParams)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		userUpdate := bson.M{"$set": bson.M{"password_hash": passwordHash}}
+
+		_, err = users.UpdateOne(sctx, bson.M{"_id": userValue.ID}, userUpdate)
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+func isDuplicateKeyError(err error) bool {
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, writeError := range writeException.WriteErrors {
+			if writeError.Code == 11000 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,77 @@
+package usecase_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegister(t *testing.T) {
+	registerResponse, err := authUseCase.Register("alice", "Alice@example.com", "correct horse")
+	require.NoError(t, err)
+	require.Equal(t, "alice@example.com", registerResponse.Email)
+	require.NotEmpty(t, registerResponse.GUID)
+
+	var requestErr *errs.RequestError
+
+	_, err = authUseCase.Register("alice", "other@example.com", "correct horse")
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusConflict, requestErr.Status)
+
+	_, err = authUseCase.Register("bob", "bob@example.com", "short")
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusBadRequest, requestErr.Status)
+
+	// A username can not take the email of another account
+	_, err = authUseCase.Register("alice@example.com", "mallory@example.com", "correct horse")
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusBadRequest, requestErr.Status)
+}
+
+func TestLogin(t *testing.T) {
+	_, err := authUseCase.Register("carol", "carol@example.com", "correct horse")
+	require.NoError(t, err)
+
+	authResponse, err := authUseCase.Login("carol", "correct horse", client)
+	require.NoError(t, err)
+	require.Equal(t, "Bearer", authResponse.TokenType)
+
+	_, err = authUseCase.Login("carol@example.com", "correct horse", client)
+	require.NoError(t, err)
+
+	var requestErr *errs.RequestError
+
+	_, err = authUseCase.Login("carol", "wrong password", client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusUnauthorized, requestErr.Status)
+
+	_, err = authUseCase.Login("nobody", "correct horse", client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusUnauthorized, requestErr.Status)
+}
+
+func TestChangePassword(t *testing.T) {
+	_, err := authUseCase.Register("dave", "dave@example.com", "correct horse")
+	require.NoError(t, err)
+
+	authResponse, err := authUseCase.Login("dave", "correct horse", client)
+	require.NoError(t, err)
+
+	var requestErr *errs.RequestError
+
+	err = authUseCase.ChangePassword(authResponse.AccessToken, "wrong password", "battery staple", client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+
+	err = authUseCase.ChangePassword(authResponse.AccessToken, "correct horse", "battery staple", client)
+	require.NoError(t, err)
+
+	_, err = authUseCase.Login("dave", "correct horse", client)
+	require.Error(t, err)
+
+	_, err = authUseCase.Login("dave", "battery staple", client)
+	require.NoError(t, err)
+}
@@ -0,0 +1,412 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/models"
+	"github.com/flaambe/authservice/views"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+const (
+	defaultUserSearchLimit = 50
+	maxUserSearchLimit     = 500
+)
+
+// CreateUser registers a user for guid, generating one when guid is empty, so
+// that it can authenticate when auto-provisioning is disabled.
+func (a *AuthUsecase) CreateUser(guid string) (views.UserResponse, error) {
+	var userResponse views.UserResponse
+
+	if guid == "" {
+		guid = uuid.New().String()
+	}
+
+	_, err := uuid.Parse(guid)
+	if err != nil {
+		return userResponse, errs.New(http.StatusBadRequest, err.Error(), err)
+	}
+
+	user := models.User{GUID: guid}
+
+	_, err = a.db.Collection("users").InsertOne(context.Background(), user)
+	if err != nil {
+		if isDuplicateKeyError(err) {
+			return userResponse, errs.New(http.StatusConflict, "user already exists", err)
+		}
+
+		return userResponse, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	userResponse = views.UserResponse{GUID: user.GUID}
+
+	return userResponse, nil
+}
+
+// SetUserDisabled disables or re-enables the user with guid. Disabling a user
+// also revokes all of its tokens.
+func (a *AuthUsecase) SetUserDisabled(guid string, disabled bool) (views.UserResponse, error) {
+	var userResponse views.UserResponse
+
+	users := a.db.Collection("users")
+
+	err := a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		userValue := models.User{}
+		userFilter := bson.M{"guid": guid}
+		userUpdate := bson.M{"$set": bson.M{"disabled": disabled}}
+		opt := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		err = users.FindOneAndUpdate(sctx, userFilter, userUpdate, opt).Decode(&userValue)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return errs.New(http.StatusNotFound, "user not found", nil)
+		}
+
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		if disabled {
+			_, err = a.revokeTokens(sctx, models.EventUserLoggedOut, bson.M{"user_id": userValue.ID})
+			if err != nil {
+				sctx.AbortTransaction(sctx)
+				return err
+			}
+		}
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		userResponse = newUserResponse(userValue)
+
+		return nil
+	})
+
+	return userResponse, err
+}
+
+// SetUserGrants replaces the roles and scopes of the user with guid. Access
+// tokens pick them up when they are issued or refreshed.
+func (a *AuthUsecase) SetUserGrants(guid string, roles, scopes []string) (views.UserResponse, error) {
+	var userResponse views.UserResponse
+
+	userValue := models.User{}
+	userUpdate := bson.M{"$set": bson.M{"roles": roles, "scopes": scopes}}
+	opt := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := a.db.Collection("users").FindOneAndUpdate(context.Background(), bson.M{"guid": guid}, userUpdate, opt).Decode(&userValue)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return userResponse, errs.New(http.StatusNotFound, "user not found", nil)
+	}
+
+	if err != nil {
+		return userResponse, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	userResponse = newUserResponse(userValue)
+
+	return userResponse, nil
+}
+
+// SearchUsers lists the users matching req, ordered by GUID.
+func (a *AuthUsecase) SearchUsers(req views.UserSearchRequest) (views.UserListResponse, error) {
+	var listResponse views.UserListResponse
+
+	users := a.db.Collection("users")
+
+	filter := bson.M{}
+	if req.Query != "" {
+		prefix := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(req.Query), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"guid": req.Query},
+			bson.M{"username": prefix},
+			bson.M{"email": prefix},
+		}
+	}
+
+	if req.Disabled != nil {
+		filter["disabled"] = *req.Disabled
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultUserSearchLimit
+	} else if limit > maxUserSearchLimit {
+		limit = maxUserSearchLimit
+	}
+
+	total, err := users.CountDocuments(context.Background(), filter)
+	if err != nil {
+		return listResponse, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	opt := options.Find().SetSort(bson.M{"guid": 1}).SetSkip(req.Offset).SetLimit(limit)
+
+	cursor, err := users.Find(context.Background(), filter, opt)
+	if err != nil {
+		return listResponse, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	var userValues []models.User
+	if err := cursor.All(context.Background(), &userValues); err != nil {
+		return listResponse, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	listResponse.Total = total
+	listResponse.Users = make([]views.UserResponse, 0, len(userValues))
+
+	for _, u := range userValues {
+		listResponse.Users = append(listResponse.Users, newUserResponse(u))
+	}
+
+	return listResponse, nil
+}
+
+func (a *AuthUsecase) GetUser(guid string) (views.UserResponse, error) {
+	userValue, err := a.findUser(context.Background(), guid)
+	if err != nil {
+		return views.UserResponse{}, err
+	}
+
+	return newUserResponse(userValue), nil
+}
+
+// ListUserSessions returns the active sessions of the user with guid, most
+// recent first.
+func (a *AuthUsecase) ListUserSessions(guid string) ([]views.SessionResponse, error) {
+	userValue, err := a.findUser(context.Background(), guid)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"user_id":            userValue.ID,
+		"refresh_expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+	}
+	opt := options.Find().SetSort(bson.M{"created_at": -1})
+
+	cursor, err := a.db.Collection("tokens").Find(context.Background(), filter, opt)
+	if err != nil {
+		return nil, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	var sessionTokens []models.AuthToken
+	if err := cursor.All(context.Background(), &sessionTokens); err != nil {
+		return nil, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	sessions := make([]views.SessionResponse, 0, len(sessionTokens))
+	for _, t := range sessionTokens {
+		sessions = append(sessions, newSessionResponse(t))
+	}
+
+	return sessions, nil
+}
+
+// LogoutUser revokes all tokens of the user with guid.
+func (a *AuthUsecase) LogoutUser(guid string) error {
+	err := a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		userValue, err := a.findUser(sctx, guid)
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return err
+		}
+
+		_, err = a.revokeTokens(sctx, models.EventUserLoggedOut, bson.M{"user_id": userValue.ID})
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return err
+		}
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// RevokeUserSession deletes the session with sessionID of the user with guid.
+func (a *AuthUsecase) RevokeUserSession(guid, sessionID string) error {
+	id, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return errs.New(http.StatusNotFound, "session not found", err)
+	}
+
+	err = a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		userValue, err := a.findUser(sctx, guid)
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return err
+		}
+
+		revoked, err := a.revokeTokens(sctx, models.EventSessionRevoked, bson.M{"_id": id, "user_id": userValue.ID})
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return err
+		}
+
+		if len(revoked) == 0 {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusNotFound, "session not found", nil)
+		}
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// DeleteUser deletes the user with guid together with its tokens, passkeys
+// and pending one-time codes.
+func (a *AuthUsecase) DeleteUser(guid string) error {
+	err := a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		userValue, err := a.findUser(sctx, guid)
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return err
+		}
+
+		_, err = a.revokeTokens(sctx, models.EventUserLoggedOut, bson.M{"user_id": userValue.ID})
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return err
+		}
+
+		for _, collection := range []string{"webauthn_credentials", "webauthn_challenges", "one_time_codes"} {
+			_, err = a.db.Collection(collection).DeleteMany(sctx, bson.M{"user_id": userValue.ID})
+			if err != nil {
+				sctx.AbortTransaction(sctx)
+				return errs.New(http.StatusInternalServerError, "server internal error", err)
+			}
+		}
+
+		_, err = a.db.Collection("users").DeleteOne(sctx, bson.M{"_id": userValue.ID})
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Stats counts users, active sessions and revoked access tokens.
+func (a *AuthUsecase) Stats() (views.StatsResponse, error) {
+	var statsResponse views.StatsResponse
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+
+	counts := []struct {
+		collection string
+		filter     bson.M
+		count      *int64
+	}{
+		{"users", bson.M{}, &statsResponse.Users},
+		{"users", bson.M{"disabled": true}, &statsResponse.DisabledUsers},
+		{"tokens", bson.M{"refresh_expires_at": bson.M{"$gt": now}}, &statsResponse.ActiveSessions},
+		{"tokens", bson.M{"access_expires_at": bson.M{"$gt": now}}, &statsResponse.ActiveAccessTokens},
+		{"revoked_tokens", bson.M{"expires_at": bson.M{"$gt": now}}, &statsResponse.RevokedTokens},
+	}
+
+	for _, c := range counts {
+		count, err := a.db.Collection(c.collection).CountDocuments(context.Background(), c.filter)
+		if err != nil {
+			return statsResponse, errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		*c.count = count
+	}
+
+	return statsResponse, nil
+}
+
+func (a *AuthUsecase) findUser(ctx context.Context, guid string) (models.User, error) {
+	userValue := models.User{}
+
+	err := a.db.Collection("users").FindOne(ctx, bson.M{"guid": guid}).Decode(&userValue)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return userValue, errs.New(http.StatusNotFound, "user not found", nil)
+	}
+
+	if err != nil {
+		return userValue, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	return userValue, nil
+}
+
+func newUserResponse(user models.User) views.UserResponse {
+	return views.UserResponse{
+		GUID:     user.GUID,
+		Username: user.Username,
+		Email:    user.Email,
+		Disabled: user.Disabled,
+		Roles:    user.Roles,
+		Scopes:   user.Scopes,
+
+		EmailVerified: user.EmailVerified,
+		TOTPEnabled:   user.TOTPEnabled,
+	}
+}
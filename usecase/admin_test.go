@@ -0,0 +1,114 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/models"
+	"github.com/flaambe/authservice/usecase"
+	"github.com/flaambe/authservice/views"
+	"github.com/stretchr/testify/require"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAutoProvisionDisabled(t *testing.T) {
+	strictUseCase, err := usecase.NewAuthUsecase(dbConfig.DB, signer, usecase.WithAutoProvisionDisabled())
+	require.NoError(t, err)
+
+	var requestErr *errs.RequestError
+
+	_, err = strictUseCase.Auth("9c1e8a7e-6f0a-4d0e-9a55-0d7f3b2f4a11", client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+
+	userResponse, err := strictUseCase.CreateUser("9c1e8a7e-6f0a-4d0e-9a55-0d7f3b2f4a11")
+	require.NoError(t, err)
+
+	_, err = strictUseCase.Auth(userResponse.GUID, client)
+	require.NoError(t, err)
+}
+
+func TestSetUserDisabled(t *testing.T) {
+	userResponse, err := authUseCase.CreateUser("")
+	require.NoError(t, err)
+
+	authResponse, err := authUseCase.Auth(userResponse.GUID, client)
+	require.NoError(t, err)
+
+	userResponse, err = authUseCase.SetUserDisabled(userResponse.GUID, true)
+	require.NoError(t, err)
+	require.True(t, userResponse.Disabled)
+
+	// Existing tokens are revoked
+	user := models.User{}
+	err = dbConfig.DB.Collection("users").FindOne(context.TODO(), bson.M{"guid": userResponse.GUID}).Decode(&user)
+	require.NoError(t, err)
+
+	count, err := dbConfig.DB.Collection("tokens").CountDocuments(context.TODO(), bson.M{"user_id": user.ID})
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	var requestErr *errs.RequestError
+
+	_, err = authUseCase.RefreshToken(authResponse.AccessToken, authResponse.RefreshToken, client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+
+	_, err = authUseCase.Auth(userResponse.GUID, client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+
+	_, err = authUseCase.SetUserDisabled(userResponse.GUID, false)
+	require.NoError(t, err)
+
+	_, err = authUseCase.Auth(userResponse.GUID, client)
+	require.NoError(t, err)
+
+	_, err = authUseCase.SetUserDisabled("unknown", true)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusNotFound, requestErr.Status)
+}
+
+func TestAdminUserManagement(t *testing.T) {
+	registerResponse, err := authUseCase.Register("operator_target", "operator.target@example.com", "correct horse battery")
+	require.NoError(t, err)
+
+	listResponse, err := authUseCase.SearchUsers(views.UserSearchRequest{Query: "OPERATOR_T"})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), listResponse.Total)
+	require.Equal(t, registerResponse.GUID, listResponse.Users[0].GUID)
+
+	_, err = authUseCase.Auth(registerResponse.GUID, client)
+	require.NoError(t, err)
+
+	_, err = authUseCase.Auth(registerResponse.GUID, client)
+	require.NoError(t, err)
+
+	sessions, err := authUseCase.ListUserSessions(registerResponse.GUID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+
+	statsResponse, err := authUseCase.Stats()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, statsResponse.ActiveSessions, int64(2))
+
+	err = authUseCase.LogoutUser(registerResponse.GUID)
+	require.NoError(t, err)
+
+	sessions, err = authUseCase.ListUserSessions(registerResponse.GUID)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+
+	err = authUseCase.DeleteUser(registerResponse.GUID)
+	require.NoError(t, err)
+
+	var requestErr *errs.RequestError
+
+	_, err = authUseCase.GetUser(registerResponse.GUID)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusNotFound, requestErr.Status)
+}
@@ -3,13 +3,17 @@ package usecase
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/mailer"
 	"github.com/flaambe/authservice/models"
+	"github.com/flaambe/authservice/password"
 	"github.com/flaambe/authservice/token"
 	"github.com/flaambe/authservice/views"
+	"github.com/flaambe/authservice/webauthn"
 
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
@@ -21,25 +25,107 @@ import (
 )
 
 type AuthUsecase struct {
-	db *mongo.Database
+	db     *mongo.Database
+	signer *token.Signer
+
+	guidAuthDisabled      bool
+	autoProvisionDisabled bool
+	passwordParams        password.Params
+	totpIssuer            string
+	relyingParty          *webauthn.RelyingParty
+	mailer                mailer.Mailer
+	mailTemplates         *mailer.Templates
+	emailLoginURL         string
+	passwordResetURL      string
+	verifyEmailURL        string
+	lockoutPolicy         LockoutPolicy
+	sessionLimits         SessionLimits
+
+	// dummyHash is verified against when the login is unknown, so that a
+	// missing account takes as long to reject as a wrong password.
+	dummyHash string
 }
 
-func NewAuthUsecase(db *mongo.Database) *AuthUsecase {
-	return &AuthUsecase{db}
+func NewAuthUsecase(db *mongo.Database, signer *token.Signer, opts ...Option) (*AuthUsecase, error) {
+	a := &AuthUsecase{
+		db:             db,
+		signer:         signer,
+		passwordParams: password.DefaultParams,
+		totpIssuer:     "authservice",
+		mailTemplates:  mailer.DefaultTemplates(),
+		lockoutPolicy:  DefaultLockoutPolicy,
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	dummyHash, err := password.Hash("dummy password", a.passwordParams)
+	if err != nil {
+		return nil, err
+	}
+
+	a.dummyHash = dummyHash
+
+	return a, nil
+}
+
+// maxTransactionAttempts bounds how often useSession runs a function whose
+// transaction keeps failing with a transient error.
+const maxTransactionAttempts = 10
+
+// useSession runs fn in a new session. When the transaction of fn fails with
+// a transient error, such as a write conflict on a shared counter with a
+// concurrent transaction, fn is run again from the start, so it must not
+// accumulate state across runs.
+func (a *AuthUsecase) useSession(fn func(mongo.SessionContext) error) error {
+	for attempt := 1; ; attempt++ {
+		err := a.db.Client().UseSession(context.Background(), fn)
+		if attempt == maxTransactionAttempts || !isTransientTransactionError(err) {
+			return err
+		}
+	}
+}
+
+func isTransientTransactionError(err error) bool {
+	var requestErr *errs.RequestError
+	if errors.As(err, &requestErr) {
+		err = requestErr.Err
+	}
+
+	var commandErr mongo.CommandError
+
+	return errors.As(err, &commandErr) && commandErr.HasErrorLabel("TransientTransactionError")
 }
 
-func (a *AuthUsecase) Auth(guid string) (views.AuthResponse, error) {
+func (a *AuthUsecase) Auth(guid string, client views.ClientInfo) (views.AuthResponse, error) {
+	keys := a.throttleKeys(client, guid)
+	reserved, err := a.reserveAttempt(keys)
+	if err != nil {
+		return views.AuthResponse{}, err
+	}
+
+	authResponse, err := a.auth(guid, client)
+	a.recordAttempt(reserved, err)
+
+	return authResponse, err
+}
+
+func (a *AuthUsecase) auth(guid string, client views.ClientInfo) (views.AuthResponse, error) {
 	var authResponse views.AuthResponse
 
+	if a.guidAuthDisabled {
+		return authResponse, errs.New(http.StatusForbidden, "guid authentication is disabled", nil)
+	}
+
 	_, err := uuid.Parse(guid)
 	if err != nil {
 		return authResponse, errs.New(http.StatusBadRequest, err.Error(), err)
 	}
 
 	users := a.db.Collection("users")
-	tokens := a.db.Collection("tokens")
 
-	err = a.db.Client().UseSession(context.Background(), func(sctx mongo.SessionContext) error {
+	err = a.useSession(func(sctx mongo.SessionContext) error {
 		err := sctx.StartTransaction(options.Transaction().
 			SetReadConcern(readconcern.Snapshot()).
 			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
@@ -49,46 +135,35 @@ func (a *AuthUsecase) Auth(guid string) (views.AuthResponse, error) {
 			return err
 		}
 
-		opt := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
 		userFilter := bson.M{"guid": guid}
-		userUpdate := bson.M{"$set": bson.M{"guid": guid}}
-
 		userValue := models.User{}
 
-		err = users.FindOneAndUpdate(sctx, userFilter, userUpdate, opt).Decode(&userValue)
-		if err != nil {
-			sctx.AbortTransaction(sctx)
-			return errs.New(http.StatusInternalServerError, "server internal error", err)
-		}
-
-		newAccessToken, err := token.CreateAccessToken(userValue.GUID)
-		if err != nil {
-			return errs.New(http.StatusInternalServerError, "server internal error", err)
-		}
+		if a.autoProvisionDisabled {
+			err = users.FindOne(sctx, userFilter).Decode(&userValue)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return errs.New(http.StatusForbidden, "user not found", nil)
+			}
+		} else {
+			opt := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+			userUpdate := bson.M{"$set": bson.M{"guid": guid}}
 
-		newRefreshToken, err := token.CreateRefreshToken(userValue.GUID)
-		if err != nil {
-			return errs.New(http.StatusInternalServerError, "server internal error", err)
+			err = users.FindOneAndUpdate(sctx, userFilter, userUpdate, opt).Decode(&userValue)
 		}
 
-		hashedRefreshToken, err := token.HashToken(newRefreshToken)
 		if err != nil {
+			sctx.AbortTransaction(sctx)
 			return errs.New(http.StatusInternalServerError, "server internal error", err)
 		}
 
-		newTokenDocument := models.AuthToken{
-			UserID:           userValue.ID,
-			AccessToken:      newAccessToken,
-			RefreshToken:     hashedRefreshToken,
-			TokenType:        "Bearer",
-			AccessExpiresAt:  primitive.NewDateTimeFromTime(time.Now().Add(time.Minute * token.AccessTokenDuration)),
-			RefreshExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(time.Minute * token.RefreshTokenDuration)),
+		if userValue.Disabled {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusForbidden, "user is disabled", nil)
 		}
 
-		_, err = tokens.InsertOne(sctx, newTokenDocument)
+		authResponse, err = a.issueTokens(sctx, userValue, nil, client)
 		if err != nil {
 			sctx.AbortTransaction(sctx)
-			return errs.New(http.StatusInternalServerError, "server internal error", err)
+			return err
 		}
 
 		err = sctx.CommitTransaction(sctx)
@@ -96,26 +171,32 @@ func (a *AuthUsecase) Auth(guid string) (views.AuthResponse, error) {
 			return errs.New(http.StatusInternalServerError, "server internal error", err)
 		}
 
-		authResponse = views.AuthResponse{
-			AccessToken:  newTokenDocument.AccessToken,
-			TokenType:    newTokenDocument.TokenType,
-			ExpiresIn:    int((token.AccessTokenDuration * time.Minute).Seconds()),
-			RefreshToken: base64.StdEncoding.EncodeToString([]byte(newRefreshToken)),
-		}
-
 		return nil
 	})
 
 	return authResponse, err
 }
 
-func (a *AuthUsecase) RefreshToken(accessToken, refreshToken string) (views.RefreshResponse, error) {
+func (a *AuthUsecase) RefreshToken(accessToken, refreshToken string, client views.ClientInfo) (views.RefreshResponse, error) {
+	keys := a.throttleKeys(client, "")
+	reserved, err := a.reserveAttempt(keys)
+	if err != nil {
+		return views.RefreshResponse{}, err
+	}
+
+	refreshResponse, err := a.refreshToken(accessToken, refreshToken, client)
+	a.recordAttempt(reserved, err)
+
+	return refreshResponse, err
+}
+
+func (a *AuthUsecase) refreshToken(accessToken, refreshToken string, client views.ClientInfo) (views.RefreshResponse, error) {
 	var refreshResponse views.RefreshResponse
 
 	users := a.db.Collection("users")
 	tokens := a.db.Collection("tokens")
 
-	err := a.db.Client().UseSession(context.Background(), func(sctx mongo.SessionContext) error {
+	err := a.useSession(func(sctx mongo.SessionContext) error {
 		err := sctx.StartTransaction(options.Transaction().
 			SetReadConcern(readconcern.Snapshot()).
 			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
@@ -136,6 +217,10 @@ func (a *AuthUsecase) RefreshToken(accessToken, refreshToken string) (views.Refr
 			return errs.New(http.StatusForbidden, "access token expired", nil)
 		}
 
+		if err := checkCertBinding(tokenValue, client); err != nil {
+			return err
+		}
+
 		decodedRefreshToken, err := base64.StdEncoding.DecodeString(refreshToken)
 		if err != nil {
 			return errs.New(http.StatusBadRequest, "refresh token incorrect", err)
@@ -153,12 +238,31 @@ func (a *AuthUsecase) RefreshToken(accessToken, refreshToken string) (views.Refr
 			return errs.New(http.StatusInternalServerError, "server internal error", err)
 		}
 
-		newAccessToken, err := token.CreateAccessToken(userValue.GUID)
+		if userValue.Disabled {
+			return errs.New(http.StatusForbidden, "user is disabled", nil)
+		}
+
+		// The replaced access token stays valid until it expires unless it
+		// is revoked.
+		if err := a.addRevocations(sctx, []models.AuthToken{tokenValue}); err != nil {
+			sctx.AbortTransaction(sctx)
+			return err
+		}
+
+		jti := uuid.New().String()
+		newAccessToken, err := a.signer.CreateAccessToken(token.AccessClaims{
+			UserGUID:       userValue.GUID,
+			JTI:            jti,
+			AMR:            tokenValue.AMR,
+			Roles:          userValue.Roles,
+			Scopes:         userValue.Scopes,
+			CertThumbprint: tokenValue.CertThumbprint,
+		})
 		if err != nil {
 			return errs.New(http.StatusInternalServerError, "server internal error", err)
 		}
 
-		newRefreshToken, err := token.CreateRefreshToken(userValue.GUID)
+		newRefreshToken, err := a.signer.CreateRefreshToken(userValue.GUID)
 		if err != nil {
 			return errs.New(http.StatusInternalServerError, "server internal error", err)
 		}
@@ -170,11 +274,19 @@ func (a *AuthUsecase) RefreshToken(accessToken, refreshToken string) (views.Refr
 
 		replaceToken := models.AuthToken{
 			AccessToken:      newAccessToken,
+			JTI:              jti,
 			RefreshToken:     hashedRefreshToken,
 			TokenType:        "Bearer",
 			UserID:           userValue.ID,
-			AccessExpiresAt:  primitive.NewDateTimeFromTime(time.Now().Add(time.Minute * token.AccessTokenDuration)),
-			RefreshExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(time.Minute * token.RefreshTokenDuration)),
+			AMR:              tokenValue.AMR,
+			Name:             tokenValue.Name,
+			UserAgent:        client.UserAgent,
+			ClientIP:         client.IP,
+			CreatedAt:        tokenValue.CreatedAt,
+			LastRefreshedAt:  primitive.NewDateTimeFromTime(time.Now()),
+			CertThumbprint:   tokenValue.CertThumbprint,
+			AccessExpiresAt:  primitive.NewDateTimeFromTime(time.Now().Add(a.signer.AccessTTL())),
+			RefreshExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(a.signer.RefreshTTL())),
 		}
 
 		opt := options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.After)
@@ -192,7 +304,7 @@ func (a *AuthUsecase) RefreshToken(accessToken, refreshToken string) (views.Refr
 		refreshResponse = views.RefreshResponse{
 			AccessToken:  tokenValue.AccessToken,
 			TokenType:    tokenValue.TokenType,
-			ExpiresIn:    int((token.AccessTokenDuration * time.Minute).Seconds()),
+			ExpiresIn:    int(a.signer.AccessTTL().Seconds()),
 			RefreshToken: base64.StdEncoding.EncodeToString([]byte(newRefreshToken)),
 		}
 
@@ -202,10 +314,10 @@ func (a *AuthUsecase) RefreshToken(accessToken, refreshToken string) (views.Refr
 	return refreshResponse, err
 }
 
-func (a *AuthUsecase) DeleteToken(accessToken, refreshToken string) error {
+func (a *AuthUsecase) DeleteToken(accessToken, refreshToken string, client views.ClientInfo) error {
 	tokens := a.db.Collection("tokens")
 
-	err := a.db.Client().UseSession(context.Background(), func(sctx mongo.SessionContext) error {
+	err := a.useSession(func(sctx mongo.SessionContext) error {
 		err := sctx.StartTransaction(options.Transaction().
 			SetReadConcern(readconcern.Snapshot()).
 			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
@@ -226,6 +338,10 @@ func (a *AuthUsecase) DeleteToken(accessToken, refreshToken string) error {
 			return errs.New(http.StatusForbidden, "access token expired", nil)
 		}
 
+		if err := checkCertBinding(tokenValue, client); err != nil {
+			return err
+		}
+
 		decodedRefreshToken, err := base64.StdEncoding.DecodeString(refreshToken)
 		if err != nil {
 			return errs.New(http.StatusBadRequest, "refresh token incorrect", err)
@@ -236,10 +352,10 @@ func (a *AuthUsecase) DeleteToken(accessToken, refreshToken string) error {
 			return errs.New(http.StatusForbidden, "Access forbidden", nil)
 		}
 
-		err = tokens.FindOneAndDelete(sctx, filterByAccessToken).Err()
+		_, err = a.revokeTokens(sctx, models.EventSessionRevoked, bson.M{"_id": tokenValue.ID})
 		if err != nil {
 			sctx.AbortTransaction(sctx)
-			return errs.New(http.StatusInternalServerError, "server internal error", err)
+			return err
 		}
 
 		err = sctx.CommitTransaction(sctx)
@@ -253,10 +369,10 @@ func (a *AuthUsecase) DeleteToken(accessToken, refreshToken string) error {
 	return err
 }
 
-func (a *AuthUsecase) DeleteAllTokens(accessToken string) error {
+func (a *AuthUsecase) DeleteAllTokens(accessToken string, client views.ClientInfo) error {
 	tokens := a.db.Collection("tokens")
 
-	err := a.db.Client().UseSession(context.Background(), func(sctx mongo.SessionContext) error {
+	err := a.useSession(func(sctx mongo.SessionContext) error {
 		err := sctx.StartTransaction(options.Transaction().
 			SetReadConcern(readconcern.Snapshot()).
 			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
@@ -277,12 +393,16 @@ func (a *AuthUsecase) DeleteAllTokens(accessToken string) error {
 			return errs.New(http.StatusForbidden, "access token expired", nil)
 		}
 
+		if err := checkCertBinding(tokenValue, client); err != nil {
+			return err
+		}
+
 		// Delete all tokens for user
 		deleteFilter := bson.M{"user_id": tokenValue.UserID}
-		_, err = tokens.DeleteMany(sctx, deleteFilter)
+		_, err = a.revokeTokens(sctx, models.EventUserLoggedOut, deleteFilter)
 		if err != nil {
 			sctx.AbortTransaction(sctx)
-			return errs.New(http.StatusInternalServerError, "server internal error", err)
+			return err
 		}
 
 		err = sctx.CommitTransaction(sctx)
@@ -295,3 +415,109 @@ func (a *AuthUsecase) DeleteAllTokens(accessToken string) error {
 
 	return err
 }
+
+// authenticate resolves a still valid access token presented by client to its
+// token document and user within the session transaction.
+func (a *AuthUsecase) authenticate(sctx mongo.SessionContext, accessToken string, client views.ClientInfo) (models.AuthToken, models.User, error) {
+	tokenValue := models.AuthToken{}
+	userValue := models.User{}
+
+	err := a.db.Collection("tokens").FindOne(sctx, bson.M{"access_token": accessToken}).Decode(&tokenValue)
+	if err != nil {
+		return tokenValue, userValue, errs.New(http.StatusForbidden, "access forbidden", err)
+	}
+
+	if tokenValue.AccessExpiresAt.Time().Before(time.Now()) {
+		return tokenValue, userValue, errs.New(http.StatusForbidden, "access token expired", nil)
+	}
+
+	if err := checkCertBinding(tokenValue, client); err != nil {
+		return tokenValue, userValue, err
+	}
+
+	err = a.db.Collection("users").FindOne(sctx, bson.M{"_id": tokenValue.UserID}).Decode(&userValue)
+	if err != nil {
+		return tokenValue, userValue, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	if userValue.Disabled {
+		return tokenValue, userValue, errs.New(http.StatusForbidden, "user is disabled", nil)
+	}
+
+	return tokenValue, userValue, nil
+}
+
+// checkCertBinding rejects a token bound to a client certificate when client
+// did not present that certificate.
+func checkCertBinding(tokenValue models.AuthToken, client views.ClientInfo) error {
+	if tokenValue.CertThumbprint != "" && tokenValue.CertThumbprint != client.CertThumbprint {
+		return errs.New(http.StatusForbidden, "access forbidden", nil)
+	}
+
+	return nil
+}
+
+// issueTokens starts a new session for user from client: it creates an access
+// and refresh token pair and stores it in the tokens collection within the
+// session transaction. amr records the authentication methods the user
+// completed.
+func (a *AuthUsecase) issueTokens(sctx mongo.SessionContext, user models.User, amr []string, client views.ClientInfo) (views.AuthResponse, error) {
+	var authResponse views.AuthResponse
+
+	if err := a.enforceSessionLimits(sctx, user, client); err != nil {
+		return authResponse, err
+	}
+
+	jti := uuid.New().String()
+	newAccessToken, err := a.signer.CreateAccessToken(token.AccessClaims{
+		UserGUID:       user.GUID,
+		JTI:            jti,
+		AMR:            amr,
+		Roles:          user.Roles,
+		Scopes:         user.Scopes,
+		CertThumbprint: client.CertThumbprint,
+	})
+	if err != nil {
+		return authResponse, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	newRefreshToken, err := a.signer.CreateRefreshToken(user.GUID)
+	if err != nil {
+		return authResponse, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	hashedRefreshToken, err := token.HashToken(newRefreshToken)
+	if err != nil {
+		return authResponse, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	newTokenDocument := models.AuthToken{
+		UserID:           user.ID,
+		AccessToken:      newAccessToken,
+		JTI:              jti,
+		RefreshToken:     hashedRefreshToken,
+		TokenType:        "Bearer",
+		AMR:              amr,
+		Name:             sessionName(client),
+		UserAgent:        client.UserAgent,
+		ClientIP:         client.IP,
+		CertThumbprint:   client.CertThumbprint,
+		CreatedAt:        primitive.NewDateTimeFromTime(time.Now()),
+		AccessExpiresAt:  primitive.NewDateTimeFromTime(time.Now().Add(a.signer.AccessTTL())),
+		RefreshExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(a.signer.RefreshTTL())),
+	}
+
+	_, err = a.db.Collection("tokens").InsertOne(sctx, newTokenDocument)
+	if err != nil {
+		return authResponse, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	authResponse = views.AuthResponse{
+		AccessToken:  newTokenDocument.AccessToken,
+		TokenType:    newTokenDocument.TokenType,
+		ExpiresIn:    int(a.signer.AccessTTL().Seconds()),
+		RefreshToken: base64.StdEncoding.EncodeToString([]byte(newRefreshToken)),
+	}
+
+	return authResponse, nil
+}
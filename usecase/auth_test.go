@@ -12,7 +12,9 @@ import (
 	"github.com/flaambe/authservice/errs"
 	"github.com/flaambe/authservice/models"
 	"github.com/flaambe/authservice/mongoconf"
+	"github.com/flaambe/authservice/token"
 	"github.com/flaambe/authservice/usecase"
+	"github.com/flaambe/authservice/views"
 	"github.com/stretchr/testify/require"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -21,6 +23,13 @@ import (
 var (
 	dbConfig    *mongoconf.Config
 	authUseCase *usecase.AuthUsecase
+	client      views.ClientInfo
+
+	tokenConfig = token.Config{
+		AccessSecret:  []byte("access-secret-used-by-the-usecase-tests"),
+		RefreshSecret: []byte("refresh-secret-used-by-the-usecase-tests"),
+	}
+	signer = token.NewSigner(tokenConfig)
 )
 
 func TestMain(m *testing.M) {
@@ -38,7 +47,10 @@ func TestMain(m *testing.M) {
 		log.Fatal(err)
 	}
 
-	authUseCase = usecase.NewAuthUsecase(dbConfig.DB)
+	authUseCase, err = usecase.NewAuthUsecase(dbConfig.DB, signer)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	exitVal := m.Run()
 
@@ -49,7 +61,7 @@ func TestMain(m *testing.M) {
 
 func TestAuth(t *testing.T) {
 	// Validate GUID and Token type
-	authResponse, err := authUseCase.Auth("4aa32cc5-d0e6-49e7-897d-d2b26748b7d3")
+	authResponse, err := authUseCase.Auth("4aa32cc5-d0e6-49e7-897d-d2b26748b7d3", client)
 	require.NoError(t, err)
 	require.Equal(t, "Bearer", authResponse.TokenType)
 
@@ -59,25 +71,25 @@ func TestAuth(t *testing.T) {
 }
 
 func TestRefreshToken(t *testing.T) {
-	authResponse, err := authUseCase.Auth("4aa32cc5-d0e6-49e7-897d-d2b26748b7d3")
+	authResponse, err := authUseCase.Auth("4aa32cc5-d0e6-49e7-897d-d2b26748b7d3", client)
 	require.NoError(t, err)
 
-	_, err = authUseCase.RefreshToken(authResponse.AccessToken, authResponse.RefreshToken)
+	_, err = authUseCase.RefreshToken(authResponse.AccessToken, authResponse.RefreshToken, client)
 	require.NoError(t, err)
 
 	var requestErr *errs.RequestError
 
-	_, err = authUseCase.RefreshToken("invalid access token", "invalid refresh token")
+	_, err = authUseCase.RefreshToken("invalid access token", "invalid refresh token", client)
 	if errors.As(err, &requestErr) {
 		require.Equal(t, http.StatusForbidden, requestErr.Status)
 	}
 }
 
 func TestDeleteToken(t *testing.T) {
-	authResponse, err := authUseCase.Auth("4aa32cc5-d0e6-49e7-897d-d2b26748b7d3")
+	authResponse, err := authUseCase.Auth("4aa32cc5-d0e6-49e7-897d-d2b26748b7d3", client)
 	require.NoError(t, err)
 
-	err = authUseCase.DeleteToken(authResponse.AccessToken, authResponse.RefreshToken)
+	err = authUseCase.DeleteToken(authResponse.AccessToken, authResponse.RefreshToken, client)
 	require.NoError(t, err)
 
 	filter := bson.M{"refresh_token": authResponse.RefreshToken}
@@ -86,17 +98,17 @@ func TestDeleteToken(t *testing.T) {
 
 	var requestErr *errs.RequestError
 
-	err = authUseCase.DeleteToken("invalid access token", "invalid refresh token")
+	err = authUseCase.DeleteToken("invalid access token", "invalid refresh token", client)
 	if errors.As(err, &requestErr) {
 		require.Equal(t, http.StatusForbidden, requestErr.Status)
 	}
 }
 
 func TestDeleteAllTokens(t *testing.T) {
-	authResponse, err := authUseCase.Auth("4aa32cc5-d0e6-49e7-897d-d2b26748b7d3")
+	authResponse, err := authUseCase.Auth("4aa32cc5-d0e6-49e7-897d-d2b26748b7d3", client)
 	require.NoError(t, err)
 
-	err = authUseCase.DeleteAllTokens(authResponse.AccessToken)
+	err = authUseCase.DeleteAllTokens(authResponse.AccessToken, client)
 	require.NoError(t, err)
 
 	user := models.User{}
@@ -112,7 +124,7 @@ func TestDeleteAllTokens(t *testing.T) {
 
 	var requestErr *errs.RequestError
 
-	err = authUseCase.DeleteToken("invalid access token", "invalid refresh token")
+	err = authUseCase.DeleteToken("invalid access token", "invalid refresh token", client)
 	if errors.As(err, &requestErr) {
 		require.Equal(t, http.StatusForbidden, requestErr.Status)
 	}
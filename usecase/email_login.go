@@ -0,0 +1,319 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/mailer"
+	"github.com/flaambe/authservice/models"
+	"github.com/flaambe/authservice/token"
+	"github.com/flaambe/authservice/views"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+const (
+	emailLoginPurpose  = "email_login"
+	emailLoginDuration = 10 * time.Minute
+	maxCodeAttempts    = 5
+)
+
+// RequestEmailLogin mails a single-use login link and code to the user with
+// email. Unknown addresses are accepted silently and the mail is sent in the
+// background, so that the response does not reveal which accounts exist.
+func (a *AuthUsecase) RequestEmailLogin(email string, client views.ClientInfo) error {
+	if a.mailer == nil {
+		return errs.New(http.StatusNotImplemented, "email login is not configured", nil)
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return errs.New(http.StatusBadRequest, "email is invalid", err)
+	}
+
+	keys := a.mailThrottleKeys(client, address.Address)
+	reserved, err := a.reserveAttempt(keys)
+	if err != nil {
+		return err
+	}
+
+	a.recordRequest(reserved)
+	a.inBackground(func() error { return a.sendEmailLogin(address.Address) })
+
+	return nil
+}
+
+func (a *AuthUsecase) sendEmailLogin(address string) error {
+	userValue := models.User{}
+	filterByEmail := bson.M{"email": strings.ToLower(address)}
+
+	err := a.db.Collection("users").FindOne(context.Background(), filterByEmail).Decode(&userValue)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+
+	if err != nil {
+		return errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	if userValue.Disabled {
+		return nil
+	}
+
+	linkToken, code, err := a.createOneTimeCode(userValue.ID, emailLoginPurpose, emailLoginDuration)
+	if err != nil {
+		return err
+	}
+
+	link := a.emailLoginURL
+	if link != "" {
+		link += "?" + url.Values{"token": {linkToken}}.Encode()
+	}
+
+	return a.sendMail(mailer.EmailLoginTemplate, userValue.Email, mailData{
+		Code:             code,
+		Link:             link,
+		ExpiresInMinutes: int(emailLoginDuration.Minutes()),
+	})
+}
+
+// RedeemEmailLogin exchanges either the link token or the email and code pair
+// sent by RequestEmailLogin for a token pair.
+func (a *AuthUsecase) RedeemEmailLogin(linkToken, email, code string, client views.ClientInfo) (views.LoginResponse, error) {
+	var account string
+	if linkToken == "" {
+		var err error
+		if account, err = a.loginAccount(email); err != nil {
+			return views.LoginResponse{}, err
+		}
+	}
+
+	keys := a.throttleKeys(client, account)
+	reserved, err := a.reserveAttempt(keys)
+	if err != nil {
+		return views.LoginResponse{}, err
+	}
+
+	loginResponse, err := a.redeemEmailLogin(linkToken, email, code, client)
+	a.recordAttempt(reserved, err)
+
+	return loginResponse, err
+}
+
+func (a *AuthUsecase) redeemEmailLogin(linkToken, email, code string, client views.ClientInfo) (views.LoginResponse, error) {
+	var loginResponse views.LoginResponse
+
+	users := a.db.Collection("users")
+	codes := a.db.Collection("one_time_codes")
+
+	err := a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		codeValue := models.OneTimeCode{}
+		userValue := models.User{}
+
+		switch {
+		case linkToken != "":
+			filterByToken := bson.M{"purpose": emailLoginPurpose, "token_hash": token.HashSecret(linkToken)}
+
+			err = codes.FindOne(sctx, filterByToken).Decode(&codeValue)
+			if err != nil {
+				return errs.New(http.StatusForbidden, "login link invalid", err)
+			}
+		case email != "" && code != "":
+			filterByEmail := bson.M{"email": strings.ToLower(strings.TrimSpace(email))}
+
+			err = users.FindOne(sctx, filterByEmail).Decode(&userValue)
+			if err != nil {
+				return errs.New(http.StatusForbidden, "login code invalid", err)
+			}
+
+			filterByUser := bson.M{"purpose": emailLoginPurpose, "user_id": userValue.ID}
+
+			err = codes.FindOne(sctx, filterByUser).Decode(&codeValue)
+			if err != nil {
+				return errs.New(http.StatusForbidden, "login code invalid", err)
+			}
+
+			if codeValue.Attempts >= maxCodeAttempts {
+				return errs.New(http.StatusForbidden, "login code invalid", nil)
+			}
+
+			if !a.signer.CheckCodeHash(code, codeValue.CodeHash) {
+				// Keep the failed attempt even though the login fails.
+				_, err = codes.UpdateOne(sctx, bson.M{"_id": codeValue.ID}, bson.M{"$inc": bson.M{"attempts": 1}})
+				if err != nil {
+					sctx.AbortTransaction(sctx)
+					return errs.New(http.StatusInternalServerError, "server internal error", err)
+				}
+
+				err = sctx.CommitTransaction(sctx)
+				if err != nil {
+					return errs.New(http.StatusInternalServerError, "server internal error", err)
+				}
+
+				return errs.New(http.StatusForbidden, "login code invalid", nil)
+			}
+		default:
+			return errs.New(http.StatusBadRequest, "token or email and code required", nil)
+		}
+
+		if codeValue.ExpiresAt.Time().Before(time.Now()) {
+			return errs.New(http.StatusForbidden, "login code expired", nil)
+		}
+
+		_, err = codes.DeleteOne(sctx, bson.M{"_id": codeValue.ID})
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		err = users.FindOne(sctx, bson.M{"_id": codeValue.UserID}).Decode(&userValue)
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		if userValue.Disabled {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusForbidden, "user is disabled", nil)
+		}
+
+		if userValue.TOTPEnabled {
+			challenge, err := a.newMFAChallenge(userValue, []string{"email"})
+			if err != nil {
+				sctx.AbortTransaction(sctx)
+				return err
+			}
+
+			loginResponse.MFAChallengeResponse = &challenge
+		} else {
+			authResponse, err := a.issueTokens(sctx, userValue, []string{"email"}, client)
+			if err != nil {
+				sctx.AbortTransaction(sctx)
+				return err
+			}
+
+			loginResponse.AuthResponse = &authResponse
+		}
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		return nil
+	})
+
+	return loginResponse, err
+}
+
+type mailData struct {
+	Code             string
+	Link             string
+	ExpiresInMinutes int
+}
+
+// sendMail renders template name with data and sends it to address.
+func (a *AuthUsecase) sendMail(name, address string, data mailData) error {
+	msg, err := a.mailTemplates.Render(name, address, data)
+	if err != nil {
+		return errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	if err := a.mailer.Send(msg); err != nil {
+		return errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	return nil
+}
+
+// inBackground runs send after the request has returned and logs its error,
+// so that mail delivery is kept off the request path and neither its duration
+// nor its outcome reveal whether an account exists.
+func (a *AuthUsecase) inBackground(send func() error) {
+	go func() {
+		var requestErr *errs.RequestError
+		if err := send(); errors.As(err, &requestErr) {
+			slog.Error("Sending email failed", "err", requestErr.Err)
+		}
+	}()
+}
+
+// createOneTimeCode replaces any pending code of userID for purpose with a new
+// one and returns the link token and the short numeric code in clear. Failed
+// attempts on a pending code carry over, so that requesting new codes does
+// not allow more guesses.
+func (a *AuthUsecase) createOneTimeCode(userID primitive.ObjectID, purpose string, ttl time.Duration) (string, string, error) {
+	codes := a.db.Collection("one_time_codes")
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", "", errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	linkToken := base64.RawURLEncoding.EncodeToString(b)
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	filterByUser := bson.M{"user_id": userID, "purpose": purpose}
+	filterPending := bson.M{
+		"user_id":    userID,
+		"purpose":    purpose,
+		"expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+	}
+
+	pending := models.OneTimeCode{}
+
+	err = codes.FindOne(context.Background(), filterPending, options.FindOne().SetSort(bson.M{"attempts": -1})).Decode(&pending)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return "", "", errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	_, err = codes.DeleteMany(context.Background(), filterByUser)
+	if err != nil {
+		return "", "", errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	codeValue := models.OneTimeCode{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: token.HashSecret(linkToken),
+		CodeHash:  a.signer.HashCode(code),
+		Attempts:  pending.Attempts,
+		ExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(ttl)),
+	}
+
+	_, err = codes.InsertOne(context.Background(), codeValue)
+	if err != nil {
+		return "", "", errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	return linkToken, code, nil
+}
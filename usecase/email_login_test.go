@@ -0,0 +1,128 @@
+package usecase_test
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/mailer"
+	"github.com/flaambe/authservice/usecase"
+	"github.com/flaambe/authservice/views"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	codePattern  = regexp.MustCompile(`code is (\d{6})`)
+	tokenPattern = regexp.MustCompile(`token=([A-Za-z0-9_.-]+)`)
+)
+
+// waitForMail waits for a message to address other than previous, as mail is
+// sent in the background.
+func waitForMail(t *testing.T, m *mailer.MemoryMailer, address string, previous mailer.Message) mailer.Message {
+	var msg mailer.Message
+
+	require.Eventually(t, func() bool {
+		var ok bool
+		msg, ok = m.Last(address)
+
+		return ok && msg != previous
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return msg
+}
+
+func TestEmailLogin(t *testing.T) {
+	memoryMailer := mailer.NewMemoryMailer()
+	emailUseCase, err := usecase.NewAuthUsecase(dbConfig.DB, signer,
+		usecase.WithMailer(memoryMailer),
+		usecase.WithEmailLoginURL("http://localhost:8080/login/email"),
+	)
+	require.NoError(t, err)
+
+	_, err = emailUseCase.Register("frank", "frank@example.com", "correct horse")
+	require.NoError(t, err)
+
+	// Unknown addresses are not revealed
+	err = emailUseCase.RequestEmailLogin("nobody@example.com", client)
+	require.NoError(t, err)
+
+	// Login with code
+	err = emailUseCase.RequestEmailLogin("frank@example.com", client)
+	require.NoError(t, err)
+
+	msg := waitForMail(t, memoryMailer, "frank@example.com", mailer.Message{})
+
+	_, ok := memoryMailer.Last("nobody@example.com")
+	require.False(t, ok)
+
+	code := codePattern.FindStringSubmatch(msg.Body)[1]
+
+	var requestErr *errs.RequestError
+
+	_, err = emailUseCase.RedeemEmailLogin("", "frank@example.com", "000000x", client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+
+	loginResponse, err := emailUseCase.RedeemEmailLogin("", "frank@example.com", code, client)
+	require.NoError(t, err)
+	require.NotNil(t, loginResponse.AuthResponse)
+
+	// Codes are single use
+	_, err = emailUseCase.RedeemEmailLogin("", "frank@example.com", code, client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+
+	// Login with link
+	err = emailUseCase.RequestEmailLogin("frank@example.com", client)
+	require.NoError(t, err)
+
+	msg = waitForMail(t, memoryMailer, "frank@example.com", msg)
+	linkToken := tokenPattern.FindStringSubmatch(msg.Body)[1]
+
+	loginResponse, err = emailUseCase.RedeemEmailLogin(linkToken, "", "", client)
+	require.NoError(t, err)
+	require.NotNil(t, loginResponse.AuthResponse)
+
+	_, err = emailUseCase.RedeemEmailLogin(linkToken, "", "", client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+}
+
+func TestEmailLoginAttempts(t *testing.T) {
+	memoryMailer := mailer.NewMemoryMailer()
+	emailUseCase, err := usecase.NewAuthUsecase(dbConfig.DB, signer,
+		usecase.WithMailer(memoryMailer),
+		usecase.WithLockoutPolicy(usecase.LockoutPolicy{}),
+	)
+	require.NoError(t, err)
+
+	_, err = emailUseCase.Register("ken", "ken@example.com", "correct horse")
+	require.NoError(t, err)
+
+	err = emailUseCase.RequestEmailLogin("ken@example.com", views.ClientInfo{})
+	require.NoError(t, err)
+
+	msg := waitForMail(t, memoryMailer, "ken@example.com", mailer.Message{})
+
+	var requestErr *errs.RequestError
+
+	for i := 0; i < 5; i++ {
+		_, err = emailUseCase.RedeemEmailLogin("", "ken@example.com", "000000x", client)
+		require.True(t, errors.As(err, &requestErr))
+		require.Equal(t, http.StatusForbidden, requestErr.Status)
+	}
+
+	// Requesting a new code does not reset the failed attempts
+	err = emailUseCase.RequestEmailLogin("ken@example.com", views.ClientInfo{})
+	require.NoError(t, err)
+
+	msg = waitForMail(t, memoryMailer, "ken@example.com", msg)
+	code := codePattern.FindStringSubmatch(msg.Body)[1]
+
+	_, err = emailUseCase.RedeemEmailLogin("", "ken@example.com", code, client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+}
@@ -0,0 +1,199 @@
+package usecase
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/models"
+	"github.com/flaambe/authservice/views"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const eventsCounter = "events"
+
+// eventRetention is how long subscribers can resume from an event.
+const eventRetention = 24 * time.Hour
+
+// SubscribeSecurityEvents streams security events until ctx is done or the
+// stream fails, then closes the returned channel. Events after lastEventID are
+// replayed first; a zero lastEventID only streams new events.
+func (a *AuthUsecase) SubscribeSecurityEvents(ctx context.Context, lastEventID int64) (<-chan views.SecurityEventResponse, error) {
+	events := a.db.Collection("security_events")
+
+	// Watching before reading the backlog means no event falls in between.
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+
+	stream, err := events.Watch(ctx, pipeline)
+	if err != nil {
+		return nil, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	var backlog []models.SecurityEvent
+
+	if lastEventID > 0 {
+		opt := options.Find().SetSort(bson.M{"seq": 1})
+
+		cursor, err := events.Find(ctx, bson.M{"seq": bson.M{"$gt": lastEventID}}, opt)
+		if err == nil {
+			err = cursor.All(ctx, &backlog)
+		}
+
+		if err != nil {
+			stream.Close(context.Background())
+			return nil, errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+	} else {
+		counterValue := counter{}
+
+		err = a.db.Collection("counters").FindOne(ctx, bson.M{"_id": eventsCounter}).Decode(&counterValue)
+		if err != nil && err != mongo.ErrNoDocuments {
+			stream.Close(context.Background())
+			return nil, errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		lastEventID = counterValue.Seq
+	}
+
+	ch := make(chan views.SecurityEventResponse)
+
+	go func() {
+		defer close(ch)
+		defer stream.Close(context.Background())
+
+		send := func(e models.SecurityEvent) bool {
+			if e.Seq <= lastEventID {
+				return true
+			}
+
+			select {
+			case ch <- newSecurityEventResponse(e):
+				lastEventID = e.Seq
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for _, e := range backlog {
+			if !send(e) {
+				return
+			}
+		}
+
+		for stream.Next(ctx) {
+			var change struct {
+				FullDocument models.SecurityEvent `bson:"fullDocument"`
+			}
+
+			if err := stream.Decode(&change); err != nil {
+				return
+			}
+
+			if !send(change.FullDocument) {
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// recordRevocationEvents records eventType events for revokedTokens: one per
+// session for revoked sessions, one per user for users logged out everywhere.
+func (a *AuthUsecase) recordRevocationEvents(sctx mongo.SessionContext, eventType string, revokedTokens []models.AuthToken) error {
+	userIDs := bson.A{}
+	for _, t := range revokedTokens {
+		userIDs = append(userIDs, t.UserID)
+	}
+
+	cursor, err := a.db.Collection("users").Find(sctx, bson.M{"_id": bson.M{"$in": userIDs}})
+	if err != nil {
+		return errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	var users []models.User
+	if err := cursor.All(sctx, &users); err != nil {
+		return errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	guids := make(map[primitive.ObjectID]string, len(users))
+	for _, u := range users {
+		guids[u.ID] = u.GUID
+	}
+
+	var events []models.SecurityEvent
+	loggedOut := make(map[primitive.ObjectID]int)
+
+	for _, t := range revokedTokens {
+		if eventType == models.EventUserLoggedOut {
+			if i, ok := loggedOut[t.UserID]; ok {
+				events[i].JTIs = append(events[i].JTIs, t.JTI)
+				continue
+			}
+
+			loggedOut[t.UserID] = len(events)
+			events = append(events, models.SecurityEvent{Type: eventType, UserGUID: guids[t.UserID], JTIs: []string{t.JTI}})
+
+			continue
+		}
+
+		events = append(events, models.SecurityEvent{
+			Type:      eventType,
+			UserGUID:  guids[t.UserID],
+			SessionID: t.ID.Hex(),
+			JTIs:      []string{t.JTI},
+		})
+	}
+
+	return a.recordEvents(sctx, events...)
+}
+
+// recordEvents stores events within the session transaction. Subscribers are
+// notified once it commits. Like addRevocations it reserves the IDs from a
+// shared counter, so the transaction must run in useSession to be retried
+// on a write conflict.
+func (a *AuthUsecase) recordEvents(sctx mongo.SessionContext, events ...models.SecurityEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	first, err := a.reserveSeq(sctx, eventsCounter, len(events))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	documents := make([]interface{}, 0, len(events))
+	for i, e := range events {
+		e.Seq = first + int64(i)
+		e.CreatedAt = primitive.NewDateTimeFromTime(now)
+		e.ExpiresAt = primitive.NewDateTimeFromTime(now.Add(eventRetention))
+		documents = append(documents, e)
+	}
+
+	_, err = a.db.Collection("security_events").InsertMany(sctx, documents)
+	if err != nil {
+		return errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	return nil
+}
+
+func newSecurityEventResponse(e models.SecurityEvent) views.SecurityEventResponse {
+	return views.SecurityEventResponse{
+		ID:        e.Seq,
+		Type:      e.Type,
+		UserGUID:  e.UserGUID,
+		SessionID: e.SessionID,
+		JTIs:      e.JTIs,
+		KeyID:     e.KeyID,
+		Time:      e.CreatedAt.Time().Unix(),
+	}
+}
@@ -0,0 +1,124 @@
+package usecase_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flaambe/authservice/models"
+	"github.com/flaambe/authservice/views"
+	"github.com/stretchr/testify/require"
+)
+
+func nextEvent(t *testing.T, events <-chan views.SecurityEventResponse) views.SecurityEventResponse {
+	select {
+	case event, ok := <-events:
+		require.True(t, ok)
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no security event received")
+	}
+
+	return views.SecurityEventResponse{}
+}
+
+func TestSecurityEvents(t *testing.T) {
+	guid := "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := authUseCase.SubscribeSecurityEvents(ctx, 0)
+	require.NoError(t, err)
+
+	first, err := authUseCase.Auth(guid, client)
+	require.NoError(t, err)
+
+	second, err := authUseCase.Auth(guid, client)
+	require.NoError(t, err)
+
+	err = authUseCase.DeleteToken(first.AccessToken, first.RefreshToken, client)
+	require.NoError(t, err)
+
+	revoked := nextEvent(t, events)
+	require.Equal(t, models.EventSessionRevoked, revoked.Type)
+	require.Equal(t, guid, revoked.UserGUID)
+	require.NotEmpty(t, revoked.SessionID)
+	require.Equal(t, []string{tokenJTI(t, first.AccessToken)}, revoked.JTIs)
+
+	err = authUseCase.DeleteAllTokens(second.AccessToken, client)
+	require.NoError(t, err)
+
+	loggedOut := nextEvent(t, events)
+	require.Equal(t, models.EventUserLoggedOut, loggedOut.Type)
+	require.Equal(t, guid, loggedOut.UserGUID)
+	require.Equal(t, revoked.ID+1, loggedOut.ID)
+
+	// Resuming replays the events after the last one seen
+	resumeCtx, resumeCancel := context.WithCancel(context.Background())
+	defer resumeCancel()
+
+	resumed, err := authUseCase.SubscribeSecurityEvents(resumeCtx, revoked.ID)
+	require.NoError(t, err)
+	require.Equal(t, loggedOut.ID, nextEvent(t, resumed).ID)
+
+	cancel()
+
+	for range events {
+	}
+}
+
+func TestConcurrentSecurityEvents(t *testing.T) {
+	guids := []string{
+		"1b2c3d4e-5f6a-4b7c-8d9e-0f1a2b3c4d5e",
+		"2c3d4e5f-6a7b-4c8d-9e0f-1a2b3c4d5e6f",
+		"3d4e5f6a-7b8c-4d9e-8f0a-2b3c4d5e6f7a",
+		"4e5f6a7b-8c9d-4e0f-9a1b-3c4d5e6f7a8b",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := authUseCase.SubscribeSecurityEvents(ctx, 0)
+	require.NoError(t, err)
+
+	var sessions []views.AuthResponse
+	for _, guid := range guids {
+		authResponse, err := authUseCase.Auth(guid, client)
+		require.NoError(t, err)
+
+		sessions = append(sessions, authResponse)
+	}
+
+	// Every deletion reserves an event ID from the same counter
+	errs := make([]error, len(sessions))
+
+	var wg sync.WaitGroup
+	for i, session := range sessions {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			errs[i] = authUseCase.DeleteToken(session.AccessToken, session.RefreshToken, client)
+		}()
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	// Events are delivered in ID order without gaps
+	first := nextEvent(t, events)
+	for i := 1; i < len(sessions); i++ {
+		require.Equal(t, first.ID+int64(i), nextEvent(t, events).ID)
+	}
+
+	cancel()
+
+	for range events {
+	}
+}
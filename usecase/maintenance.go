@@ -0,0 +1,95 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/models"
+	"github.com/flaambe/authservice/views"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// PurgeExpired deletes expired tokens, revocations, one-time codes and
+// WebAuthn challenges right away instead of waiting for the TTL monitor.
+func (a *AuthUsecase) PurgeExpired() (views.PurgeResponse, error) {
+	var purgeResponse views.PurgeResponse
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+
+	purges := []struct {
+		collection string
+		field      string
+		deleted    *int64
+	}{
+		{"tokens", "refresh_expires_at", &purgeResponse.Tokens},
+		{"revoked_tokens", "expires_at", &purgeResponse.RevokedTokens},
+		{"one_time_codes", "expires_at", &purgeResponse.OneTimeCodes},
+		{"webauthn_challenges", "expires_at", &purgeResponse.WebAuthnChallenges},
+	}
+
+	for _, p := range purges {
+		result, err := a.db.Collection(p.collection).DeleteMany(context.Background(), bson.M{p.field: bson.M{"$lt": now}})
+		if err != nil {
+			return purgeResponse, errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		*p.deleted = result.DeletedCount
+	}
+
+	return purgeResponse, nil
+}
+
+// RecordKeyRotation notifies event stream subscribers that the signing key
+// identified by kid replaced the previous one. Every instance reports the key
+// it switched to, so a rotation is only recorded once.
+func (a *AuthUsecase) RecordKeyRotation(kid string) error {
+	events := a.db.Collection("security_events")
+
+	err := a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		last := models.SecurityEvent{}
+		filterByType := bson.M{"type": models.EventKeyRotated}
+
+		err = events.FindOne(sctx, filterByType, options.FindOne().SetSort(bson.M{"seq": -1})).Decode(&last)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		if err == nil && last.KeyID == kid {
+			sctx.AbortTransaction(sctx)
+			return nil
+		}
+
+		err = a.recordEvents(sctx, models.SecurityEvent{Type: models.EventKeyRotated, KeyID: kid})
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return err
+		}
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		return nil
+	})
+
+	return err
+}
@@ -0,0 +1,95 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/models"
+	"github.com/stretchr/testify/require"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPurgeExpired(t *testing.T) {
+	expired := primitive.NewDateTimeFromTime(time.Now().Add(-time.Hour))
+
+	_, err := dbConfig.DB.Collection("revoked_tokens").InsertOne(context.TODO(), models.RevokedToken{
+		JTI:       "purge-expired-jti",
+		ExpiresAt: expired,
+	})
+	require.NoError(t, err)
+
+	purged, err := authUseCase.PurgeExpired()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, purged.RevokedTokens, int64(1))
+
+	count, err := dbConfig.DB.Collection("revoked_tokens").CountDocuments(context.TODO(), bson.M{"jti": "purge-expired-jti"})
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
+func TestRevokeUserSession(t *testing.T) {
+	guid := "4f3e2d1c-0b9a-4877-a665-544332211000"
+
+	for i := 0; i < 2; i++ {
+		_, err := authUseCase.Auth(guid, client)
+		require.NoError(t, err)
+	}
+
+	sessions, err := authUseCase.ListUserSessions(guid)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+
+	var requestErr *errs.RequestError
+
+	err = authUseCase.RevokeUserSession("00000000-0000-4000-8000-000000000000", sessions[0].ID)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusNotFound, requestErr.Status)
+
+	err = authUseCase.RevokeUserSession(guid, sessions[0].ID)
+	require.NoError(t, err)
+
+	remaining, err := authUseCase.ListUserSessions(guid)
+	require.NoError(t, err)
+	require.Len(t, remaining, 1)
+	require.Equal(t, sessions[1].ID, remaining[0].ID)
+
+	err = authUseCase.RevokeUserSession(guid, sessions[0].ID)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusNotFound, requestErr.Status)
+}
+
+func TestRecordKeyRotation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := authUseCase.SubscribeSecurityEvents(ctx, 0)
+	require.NoError(t, err)
+
+	err = authUseCase.RecordKeyRotation("0123456789abcdef")
+	require.NoError(t, err)
+
+	rotated := nextEvent(t, events)
+	require.Equal(t, models.EventKeyRotated, rotated.Type)
+	require.Equal(t, "0123456789abcdef", rotated.KeyID)
+
+	// Other instances switching to the same key do not announce it again
+	err = authUseCase.RecordKeyRotation("0123456789abcdef")
+	require.NoError(t, err)
+
+	err = authUseCase.RecordKeyRotation("fedcba9876543210")
+	require.NoError(t, err)
+
+	rotated = nextEvent(t, events)
+	require.Equal(t, "fedcba9876543210", rotated.KeyID)
+
+	cancel()
+
+	for range events {
+	}
+}
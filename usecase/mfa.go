@@ -0,0 +1,357 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/models"
+	"github.com/flaambe/authservice/token"
+	"github.com/flaambe/authservice/totp"
+	"github.com/flaambe/authservice/views"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+const (
+	recoveryCodesCount = 10
+	// recoveryCodeBytes is the entropy of a recovery code, which is stored
+	// as an unsalted hash.
+	recoveryCodeBytes = 10
+	mfaPurpose        = "mfa"
+)
+
+func (a *AuthUsecase) EnrollTOTP(accessToken string, client views.ClientInfo) (views.TOTPEnrollResponse, error) {
+	var enrollResponse views.TOTPEnrollResponse
+
+	users := a.db.Collection("users")
+
+	err := a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		_, userValue, err := a.authenticate(sctx, accessToken, client)
+		if err != nil {
+			return err
+		}
+
+		if userValue.TOTPEnabled {
+			return errs.New(http.StatusConflict, "totp already enabled", nil)
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		userUpdate := bson.M{"$set": bson.M{"totp_secret": secret}}
+
+		_, err = users.UpdateOne(sctx, bson.M{"_id": userValue.ID}, userUpdate)
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		enrollResponse = views.TOTPEnrollResponse{
+			Secret: secret,
+			URI:    totp.URI(a.totpIssuer, accountName(userValue), secret),
+		}
+
+		return nil
+	})
+
+	return enrollResponse, err
+}
+
+// ConfirmTOTP enables TOTP once the user proves the enrolled secret with a
+// code. Wrong codes are throttled like the MFA login step, so that a stolen
+// access token can not be used to guess them and enrol another authenticator.
+func (a *AuthUsecase) ConfirmTOTP(accessToken, code string, client views.ClientInfo) (views.TOTPConfirmResponse, error) {
+	claims, _ := a.signer.ParseAccessToken(accessToken)
+
+	keys := a.throttleKeys(client, claims.UserGUID)
+	reserved, err := a.reserveAttempt(keys)
+	if err != nil {
+		return views.TOTPConfirmResponse{}, err
+	}
+
+	confirmResponse, err := a.confirmTOTP(accessToken, code, client)
+	a.recordAttempt(reserved, err)
+
+	return confirmResponse, err
+}
+
+func (a *AuthUsecase) confirmTOTP(accessToken, code string, client views.ClientInfo) (views.TOTPConfirmResponse, error) {
+	var confirmResponse views.TOTPConfirmResponse
+
+	users := a.db.Collection("users")
+
+	err := a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		_, userValue, err := a.authenticate(sctx, accessToken, client)
+		if err != nil {
+			return err
+		}
+
+		if userValue.TOTPEnabled {
+			return errs.New(http.StatusConflict, "totp already enabled", nil)
+		}
+
+		if userValue.TOTPSecret == "" {
+			return errs.New(http.StatusBadRequest, "totp enrolment not started", nil)
+		}
+
+		step, ok := totp.Validate(userValue.TOTPSecret, code, time.Now())
+		if !ok {
+			return errs.New(http.StatusForbidden, "invalid code", nil)
+		}
+
+		recoveryCodes, hashedRecoveryCodes, err := generateRecoveryCodes()
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		userUpdate := bson.M{"$set": bson.M{
+			"totp_enabled":   true,
+			"totp_last_step": step,
+			"recovery_codes": hashedRecoveryCodes,
+		}}
+
+		_, err = users.UpdateOne(sctx, bson.M{"_id": userValue.ID}, userUpdate)
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		confirmResponse = views.TOTPConfirmResponse{RecoveryCodes: recoveryCodes}
+
+		return nil
+	})
+
+	return confirmResponse, err
+}
+
+// LoginMFA completes a login started by Login with either a TOTP code or one
+// of the user's recovery codes.
+func (a *AuthUsecase) LoginMFA(mfaToken, code, recoveryCode string, client views.ClientInfo) (views.AuthResponse, error) {
+	userGUID, _, _, _ := a.signer.ParseMFAToken(mfaToken)
+
+	keys := a.throttleKeys(client, userGUID)
+	reserved, err := a.reserveAttempt(keys)
+	if err != nil {
+		return views.AuthResponse{}, err
+	}
+
+	authResponse, err := a.loginMFA(mfaToken, code, recoveryCode, client)
+	a.recordAttempt(reserved, err)
+
+	return authResponse, err
+}
+
+func (a *AuthUsecase) loginMFA(mfaToken, code, recoveryCode string, client views.ClientInfo) (views.AuthResponse, error) {
+	var authResponse views.AuthResponse
+
+	userGUID, jti, amr, err := a.signer.ParseMFAToken(mfaToken)
+	if err != nil {
+		return authResponse, errs.New(http.StatusForbidden, "mfa token invalid", err)
+	}
+
+	// The token is consumed by the first attempt, successful or not, so that
+	// a leaked token does not allow guessing codes until it expires.
+	filterByToken := bson.M{"purpose": mfaPurpose, "token_hash": token.HashSecret(jti)}
+
+	err = a.db.Collection("one_time_codes").FindOneAndDelete(context.Background(), filterByToken).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return authResponse, errs.New(http.StatusForbidden, "mfa token invalid", nil)
+	}
+
+	if err != nil {
+		return authResponse, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	users := a.db.Collection("users")
+
+	err = a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		userValue := models.User{}
+		filterByGUID := bson.M{"guid": userGUID}
+
+		err = users.FindOne(sctx, filterByGUID).Decode(&userValue)
+		if err != nil {
+			return errs.New(http.StatusForbidden, "access forbidden", err)
+		}
+
+		if userValue.Disabled {
+			return errs.New(http.StatusForbidden, "user is disabled", nil)
+		}
+
+		if !userValue.TOTPEnabled {
+			return errs.New(http.StatusBadRequest, "mfa not enabled", nil)
+		}
+
+		var userUpdate bson.M
+		var factors []string
+
+		switch {
+		case code != "":
+			step, ok := totp.Validate(userValue.TOTPSecret, code, time.Now())
+			if !ok || step <= userValue.TOTPLastStep {
+				return errs.New(http.StatusForbidden, "invalid code", nil)
+			}
+
+			userUpdate = bson.M{"$set": bson.M{"totp_last_step": step}}
+			factors = []string{"otp", "mfa"}
+		case recoveryCode != "":
+			hashedRecoveryCode := token.HashSecret(recoveryCode)
+			if !containsString(userValue.RecoveryCodes, hashedRecoveryCode) {
+				return errs.New(http.StatusForbidden, "invalid recovery code", nil)
+			}
+
+			userUpdate = bson.M{"$pull": bson.M{"recovery_codes": hashedRecoveryCode}}
+			factors = []string{"mfa"}
+		default:
+			return errs.New(http.StatusBadRequest, "code or recovery code required", nil)
+		}
+
+		_, err = users.UpdateOne(sctx, bson.M{"_id": userValue.ID}, userUpdate)
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		authResponse, err = a.issueTokens(sctx, userValue, slices.Concat(amr, factors), client)
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return err
+		}
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		return nil
+	})
+
+	return authResponse, err
+}
+
+// helpers
+func (a *AuthUsecase) newMFAChallenge(user models.User, amr []string) (views.MFAChallengeResponse, error) {
+	var challenge views.MFAChallengeResponse
+
+	jti := uuid.New().String()
+	if err := a.storeOneTimeToken(user.ID, mfaPurpose, jti, a.signer.MFATTL()); err != nil {
+		return challenge, err
+	}
+
+	mfaToken, err := a.signer.CreateMFAToken(user.GUID, jti, amr)
+	if err != nil {
+		return challenge, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	challenge = views.MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+		MFAMethods:  []string{"totp", "recovery_code"},
+		MFAExpires:  int(a.signer.MFATTL().Seconds()),
+	}
+
+	return challenge, nil
+}
+
+// storeOneTimeToken records secret as a single-use token of userID for
+// purpose, valid for ttl. Only its hash is stored.
+func (a *AuthUsecase) storeOneTimeToken(userID primitive.ObjectID, purpose, secret string, ttl time.Duration) error {
+	codeValue := models.OneTimeCode{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: token.HashSecret(secret),
+		ExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(ttl)),
+	}
+
+	_, err := a.db.Collection("one_time_codes").InsertOne(context.Background(), codeValue)
+	if err != nil {
+		return errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	return nil
+}
+
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodesCount)
+	hashes := make([]string, recoveryCodesCount)
+
+	for i := range codes {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		codes[i] = hex.EncodeToString(b)
+		hashes[i] = token.HashSecret(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+func accountName(user models.User) string {
+	switch {
+	case user.Email != "":
+		return user.Email
+	case user.Username != "":
+		return user.Username
+	default:
+		return user.GUID
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,82 @@
+package usecase_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/totp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestTOTPLogin(t *testing.T) {
+	_, err := authUseCase.Register("erin", "erin@example.com", "correct horse")
+	require.NoError(t, err)
+
+	loginResponse, err := authUseCase.Login("erin", "correct horse", client)
+	require.NoError(t, err)
+	require.NotNil(t, loginResponse.AuthResponse)
+
+	enrollResponse, err := authUseCase.EnrollTOTP(loginResponse.AccessToken, client)
+	require.NoError(t, err)
+	require.Contains(t, enrollResponse.URI, "otpauth://totp/")
+
+	code, err := totp.Code(enrollResponse.Secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+
+	confirmResponse, err := authUseCase.ConfirmTOTP(loginResponse.AccessToken, code, client)
+	require.NoError(t, err)
+	require.Len(t, confirmResponse.RecoveryCodes, 10)
+
+	// Password alone now yields an MFA challenge instead of tokens
+	loginResponse, err = authUseCase.Login("erin", "correct horse", client)
+	require.NoError(t, err)
+	require.Nil(t, loginResponse.AuthResponse)
+	require.True(t, loginResponse.MFARequired)
+
+	var requestErr *errs.RequestError
+
+	// The code used for confirmation can not be replayed
+	_, err = authUseCase.LoginMFA(loginResponse.MFAToken, code, "", client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+
+	code, err = totp.Code(enrollResponse.Secret, totp.Step(time.Now())+1)
+	require.NoError(t, err)
+
+	// The MFA token was consumed by the failed attempt
+	_, err = authUseCase.LoginMFA(loginResponse.MFAToken, code, "", client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+
+	loginResponse, err = authUseCase.Login("erin", "correct horse", client)
+	require.NoError(t, err)
+
+	authResponse, err := authUseCase.LoginMFA(loginResponse.MFAToken, code, "", client)
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(authResponse.AccessToken, claims, func(*jwt.Token) (interface{}, error) {
+		return tokenConfig.AccessSecret, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"pwd", "otp", "mfa"}, claims["amr"])
+
+	// Recovery codes are single use
+	loginResponse, err = authUseCase.Login("erin", "correct horse", client)
+	require.NoError(t, err)
+
+	_, err = authUseCase.LoginMFA(loginResponse.MFAToken, "", confirmResponse.RecoveryCodes[0], client)
+	require.NoError(t, err)
+
+	loginResponse, err = authUseCase.Login("erin", "correct horse", client)
+	require.NoError(t, err)
+
+	_, err = authUseCase.LoginMFA(loginResponse.MFAToken, "", confirmResponse.RecoveryCodes[0], client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+}
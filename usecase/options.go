@@ -0,0 +1,97 @@
+package usecase
+
+import (
+	"github.com/flaambe/authservice/mailer"
+	"github.com/flaambe/authservice/password"
+	"github.com/flaambe/authservice/webauthn"
+)
+
+// Option configures an AuthUsecase.
+type Option func(*AuthUsecase)
+
+// WithGUIDAuthDisabled rejects Auth calls so that tokens can only be obtained
+// with real credentials.
+func WithGUIDAuthDisabled() Option {
+	return func(a *AuthUsecase) {
+		a.guidAuthDisabled = true
+	}
+}
+
+// WithAutoProvisionDisabled makes Auth accept only users that already exist
+// instead of creating one for every GUID presented.
+func WithAutoProvisionDisabled() Option {
+	return func(a *AuthUsecase) {
+		a.autoProvisionDisabled = true
+	}
+}
+
+// WithPasswordParams sets the argon2id parameters used for new password hashes.
+func WithPasswordParams(p password.Params) Option {
+	return func(a *AuthUsecase) {
+		a.passwordParams = p
+	}
+}
+
+// WithTOTPIssuer sets the issuer shown by authenticator apps.
+func WithTOTPIssuer(issuer string) Option {
+	return func(a *AuthUsecase) {
+		a.totpIssuer = issuer
+	}
+}
+
+// WithRelyingParty enables passkey registration and login for rp.
+func WithRelyingParty(rp *webauthn.RelyingParty) Option {
+	return func(a *AuthUsecase) {
+		a.relyingParty = rp
+	}
+}
+
+// WithMailer enables the flows that deliver codes by email.
+func WithMailer(m mailer.Mailer) Option {
+	return func(a *AuthUsecase) {
+		a.mailer = m
+	}
+}
+
+// WithEmailLoginURL sets the page that email login links point to. The link
+// token is appended as the token query parameter.
+func WithEmailLoginURL(u string) Option {
+	return func(a *AuthUsecase) {
+		a.emailLoginURL = u
+	}
+}
+
+// WithMailTemplates replaces the built-in email templates.
+func WithMailTemplates(t *mailer.Templates) Option {
+	return func(a *AuthUsecase) {
+		a.mailTemplates = t
+	}
+}
+
+// WithPasswordResetURL sets the page that password reset links point to.
+func WithPasswordResetURL(u string) Option {
+	return func(a *AuthUsecase) {
+		a.passwordResetURL = u
+	}
+}
+
+// WithVerifyEmailURL sets the page that email verification links point to.
+func WithVerifyEmailURL(u string) Option {
+	return func(a *AuthUsecase) {
+		a.verifyEmailURL = u
+	}
+}
+
+// WithLockoutPolicy replaces DefaultLockoutPolicy.
+func WithLockoutPolicy(p LockoutPolicy) Option {
+	return func(a *AuthUsecase) {
+		a.lockoutPolicy = p
+	}
+}
+
+// WithSessionLimits caps the number of active sessions per user.
+func WithSessionLimits(l SessionLimits) Option {
+	return func(a *AuthUsecase) {
+		a.sessionLimits = l
+	}
+}
@@ -0,0 +1,411 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/models"
+	"github.com/flaambe/authservice/views"
+	"github.com/flaambe/authservice/webauthn"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+const passkeyCeremonyTimeout = 5 * time.Minute
+
+func (a *AuthUsecase) BeginPasskeyRegistration(accessToken string, client views.ClientInfo) (views.PasskeyCreationResponse, error) {
+	var creationResponse views.PasskeyCreationResponse
+
+	if a.relyingParty == nil {
+		return creationResponse, errs.New(http.StatusNotImplemented, "passkeys are not configured", nil)
+	}
+
+	credentials := a.db.Collection("webauthn_credentials")
+	challenges := a.db.Collection("webauthn_challenges")
+
+	err := a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		_, userValue, err := a.authenticate(sctx, accessToken, client)
+		if err != nil {
+			return err
+		}
+
+		cursor, err := credentials.Find(sctx, bson.M{"user_id": userValue.ID})
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		var existing []models.WebAuthnCredential
+		if err = cursor.All(sctx, &existing); err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		challengeValue, err := newWebAuthnChallenge(userValue.ID, "webauthn.create")
+		if err != nil {
+			return err
+		}
+
+		result, err := challenges.InsertOne(sctx, challengeValue)
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		excludeCredentials := make([]views.CredentialDescriptor, 0, len(existing))
+		for _, credential := range existing {
+			excludeCredentials = append(excludeCredentials, views.CredentialDescriptor{
+				Type: "public-key",
+				ID:   base64.RawURLEncoding.EncodeToString(credential.CredentialID),
+			})
+		}
+
+		creationResponse = views.PasskeyCreationResponse{
+			SessionID: result.InsertedID.(primitive.ObjectID).Hex(),
+			PublicKey: views.PublicKeyCredentialCreationOptions{
+				Challenge: base64.RawURLEncoding.EncodeToString(challengeValue.Challenge),
+				RP: views.RelyingPartyEntity{
+					ID:   a.relyingParty.ID,
+					Name: a.relyingParty.Name,
+				},
+				User: views.UserEntity{
+					ID:          base64.RawURLEncoding.EncodeToString([]byte(userValue.GUID)),
+					Name:        accountName(userValue),
+					DisplayName: accountName(userValue),
+				},
+				PubKeyCredParams: []views.CredentialParameter{
+					{Type: "public-key", Alg: webauthn.AlgES256},
+					{Type: "public-key", Alg: webauthn.AlgEdDSA},
+					{Type: "public-key", Alg: webauthn.AlgRS256},
+				},
+				Timeout:     int(passkeyCeremonyTimeout / time.Millisecond),
+				Attestation: "none",
+				AuthenticatorSelection: views.AuthenticatorSelection{
+					ResidentKey:      "required",
+					UserVerification: "preferred",
+				},
+				ExcludeCredentials: excludeCredentials,
+			},
+		}
+
+		return nil
+	})
+
+	return creationResponse, err
+}
+
+func (a *AuthUsecase) FinishPasskeyRegistration(accessToken string, request views.PasskeyFinishRequest, client views.ClientInfo) error {
+	if a.relyingParty == nil {
+		return errs.New(http.StatusNotImplemented, "passkeys are not configured", nil)
+	}
+
+	clientDataJSON, err := base64.RawURLEncoding.DecodeString(request.Credential.Response.ClientDataJSON)
+	if err != nil {
+		return errs.New(http.StatusBadRequest, "client data incorrect", err)
+	}
+
+	attestationObject, err := base64.RawURLEncoding.DecodeString(request.Credential.Response.AttestationObject)
+	if err != nil {
+		return errs.New(http.StatusBadRequest, "attestation object incorrect", err)
+	}
+
+	challengeValue, err := a.consumeWebAuthnChallenge(request.SessionID, "webauthn.create")
+	if err != nil {
+		return err
+	}
+
+	credentials := a.db.Collection("webauthn_credentials")
+
+	err = a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		_, userValue, err := a.authenticate(sctx, accessToken, client)
+		if err != nil {
+			return err
+		}
+
+		if challengeValue.UserID != userValue.ID {
+			return errs.New(http.StatusForbidden, "access forbidden", nil)
+		}
+
+		credential, err := a.relyingParty.VerifyRegistration(challengeValue.Challenge, clientDataJSON, attestationObject)
+		if err != nil {
+			return errs.New(http.StatusBadRequest, "passkey registration failed", err)
+		}
+
+		credentialValue := models.WebAuthnCredential{
+			UserID:       userValue.ID,
+			CredentialID: credential.ID,
+			PublicKey:    credential.PublicKey,
+			SignCount:    int64(credential.SignCount),
+			CreatedAt:    primitive.NewDateTimeFromTime(time.Now()),
+		}
+
+		_, err = credentials.InsertOne(sctx, credentialValue)
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+
+			if isDuplicateKeyError(err) {
+				return errs.New(http.StatusConflict, "passkey already registered", err)
+			}
+
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// BeginPasskeyLogin starts a passkey login ceremony. It needs no credentials,
+// so the challenges it stores are counted per client IP like mail requests.
+func (a *AuthUsecase) BeginPasskeyLogin(client views.ClientInfo) (views.PasskeyRequestResponse, error) {
+	var requestResponse views.PasskeyRequestResponse
+
+	if a.relyingParty == nil {
+		return requestResponse, errs.New(http.StatusNotImplemented, "passkeys are not configured", nil)
+	}
+
+	keys := a.throttleKeys(client, "")
+	for i := range keys {
+		keys[i].key = "passkey:" + keys[i].key
+	}
+
+	reserved, err := a.reserveAttempt(keys)
+	if err != nil {
+		return requestResponse, err
+	}
+
+	a.recordRequest(reserved)
+
+	challengeValue, err := newWebAuthnChallenge(primitive.NilObjectID, "webauthn.get")
+	if err != nil {
+		return requestResponse, err
+	}
+
+	result, err := a.db.Collection("webauthn_challenges").InsertOne(context.Background(), challengeValue)
+	if err != nil {
+		return requestResponse, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	requestResponse = views.PasskeyRequestResponse{
+		SessionID: result.InsertedID.(primitive.ObjectID).Hex(),
+		PublicKey: views.PublicKeyCredentialRequestOptions{
+			Challenge:        base64.RawURLEncoding.EncodeToString(challengeValue.Challenge),
+			RPID:             a.relyingParty.ID,
+			Timeout:          int(passkeyCeremonyTimeout / time.Millisecond),
+			UserVerification: "preferred",
+		},
+	}
+
+	return requestResponse, nil
+}
+
+func (a *AuthUsecase) FinishPasskeyLogin(request views.PasskeyFinishRequest, client views.ClientInfo) (views.AuthResponse, error) {
+	keys := a.throttleKeys(client, "")
+	reserved, err := a.reserveAttempt(keys)
+	if err != nil {
+		return views.AuthResponse{}, err
+	}
+
+	authResponse, err := a.finishPasskeyLogin(request, client)
+	a.recordAttempt(reserved, err)
+
+	return authResponse, err
+}
+
+func (a *AuthUsecase) finishPasskeyLogin(request views.PasskeyFinishRequest, client views.ClientInfo) (views.AuthResponse, error) {
+	var authResponse views.AuthResponse
+
+	if a.relyingParty == nil {
+		return authResponse, errs.New(http.StatusNotImplemented, "passkeys are not configured", nil)
+	}
+
+	response := request.Credential.Response
+
+	credentialID, err := base64.RawURLEncoding.DecodeString(request.Credential.RawID)
+	if err != nil {
+		return authResponse, errs.New(http.StatusBadRequest, "credential id incorrect", err)
+	}
+
+	clientDataJSON, err := base64.RawURLEncoding.DecodeString(response.ClientDataJSON)
+	if err != nil {
+		return authResponse, errs.New(http.StatusBadRequest, "client data incorrect", err)
+	}
+
+	authenticatorData, err := base64.RawURLEncoding.DecodeString(response.AuthenticatorData)
+	if err != nil {
+		return authResponse, errs.New(http.StatusBadRequest, "authenticator data incorrect", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(response.Signature)
+	if err != nil {
+		return authResponse, errs.New(http.StatusBadRequest, "signature incorrect", err)
+	}
+
+	userHandle, err := base64.RawURLEncoding.DecodeString(response.UserHandle)
+	if err != nil {
+		return authResponse, errs.New(http.StatusBadRequest, "user handle incorrect", err)
+	}
+
+	challengeValue, err := a.consumeWebAuthnChallenge(request.SessionID, "webauthn.get")
+	if err != nil {
+		return authResponse, err
+	}
+
+	users := a.db.Collection("users")
+	credentials := a.db.Collection("webauthn_credentials")
+
+	err = a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		credentialValue := models.WebAuthnCredential{}
+
+		err = credentials.FindOne(sctx, bson.M{"credential_id": credentialID}).Decode(&credentialValue)
+		if err != nil {
+			return errs.New(http.StatusForbidden, "access forbidden", err)
+		}
+
+		userValue := models.User{}
+
+		err = users.FindOne(sctx, bson.M{"_id": credentialValue.UserID}).Decode(&userValue)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		if len(userHandle) != 0 && !bytes.Equal(userHandle, []byte(userValue.GUID)) {
+			return errs.New(http.StatusForbidden, "access forbidden", nil)
+		}
+
+		if userValue.Disabled {
+			return errs.New(http.StatusForbidden, "user is disabled", nil)
+		}
+
+		assertion, err := a.relyingParty.VerifyAssertion(challengeValue.Challenge, credentialValue.PublicKey,
+			clientDataJSON, authenticatorData, signature)
+		if err != nil {
+			return errs.New(http.StatusForbidden, "access forbidden", err)
+		}
+
+		// A counter that does not move forward means that the private key may
+		// have been copied to another authenticator.
+		signCount := int64(assertion.SignCount)
+		if (signCount != 0 || credentialValue.SignCount != 0) && signCount <= credentialValue.SignCount {
+			return errs.New(http.StatusForbidden, "passkey sign count invalid, credential may be cloned", nil)
+		}
+
+		credentialUpdate := bson.M{"$set": bson.M{
+			"sign_count":   signCount,
+			"last_used_at": primitive.NewDateTimeFromTime(time.Now()),
+		}}
+
+		_, err = credentials.UpdateOne(sctx, bson.M{"_id": credentialValue.ID}, credentialUpdate)
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		amr := []string{"hwk"}
+		if assertion.UserVerified {
+			amr = append(amr, "user")
+		}
+
+		authResponse, err = a.issueTokens(sctx, userValue, amr, client)
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return err
+		}
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		return nil
+	})
+
+	return authResponse, err
+}
+
+// consumeWebAuthnChallenge removes the pending challenge of the ceremony with
+// sessionID so that every challenge is used at most once. It runs outside the
+// ceremony transaction, so that a failed verification does not restore the
+// challenge for another try.
+func (a *AuthUsecase) consumeWebAuthnChallenge(sessionID, ceremony string) (models.WebAuthnChallenge, error) {
+	challengeValue := models.WebAuthnChallenge{}
+
+	id, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return challengeValue, errs.New(http.StatusBadRequest, "session id incorrect", err)
+	}
+
+	filter := bson.M{"_id": id, "ceremony": ceremony}
+
+	err = a.db.Collection("webauthn_challenges").FindOneAndDelete(context.Background(), filter).Decode(&challengeValue)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return challengeValue, errs.New(http.StatusForbidden, "passkey ceremony not found", nil)
+	}
+
+	if err != nil {
+		return challengeValue, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	if challengeValue.ExpiresAt.Time().Before(time.Now()) {
+		return challengeValue, errs.New(http.StatusForbidden, "passkey ceremony expired", nil)
+	}
+
+	return challengeValue, nil
+}
+
+func newWebAuthnChallenge(userID primitive.ObjectID, ceremony string) (models.WebAuthnChallenge, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return models.WebAuthnChallenge{}, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	return models.WebAuthnChallenge{
+		UserID:    userID,
+		Ceremony:  ceremony,
+		Challenge: challenge,
+		ExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(passkeyCeremonyTimeout)),
+	}, nil
+}
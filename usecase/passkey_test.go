@@ -0,0 +1,119 @@
+package usecase_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/usecase"
+	"github.com/flaambe/authservice/views"
+	"github.com/flaambe/authservice/webauthn"
+	"github.com/flaambe/authservice/webauthn/webauthntest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasskeyLogin(t *testing.T) {
+	rp := &webauthn.RelyingParty{ID: "localhost", Name: "authservice", Origin: "http://localhost:8080"}
+	passkeyUseCase, err := usecase.NewAuthUsecase(dbConfig.DB, signer, usecase.WithRelyingParty(rp))
+	require.NoError(t, err)
+
+	authenticator, err := webauthntest.NewAuthenticator()
+	require.NoError(t, err)
+
+	authResponse, err := passkeyUseCase.Auth("2b5a3c1e-8f7d-4e6a-9b0c-1d2e3f4a5b6c", client)
+	require.NoError(t, err)
+
+	// Registration
+	creationResponse, err := passkeyUseCase.BeginPasskeyRegistration(authResponse.AccessToken, client)
+	require.NoError(t, err)
+
+	challenge, err := base64.RawURLEncoding.DecodeString(creationResponse.PublicKey.Challenge)
+	require.NoError(t, err)
+
+	attestation := authenticator.Create(rp.ID, rp.Origin, challenge)
+	err = passkeyUseCase.FinishPasskeyRegistration(authResponse.AccessToken, views.PasskeyFinishRequest{
+		SessionID: creationResponse.SessionID,
+		Credential: views.PublicKeyCredential{
+			RawID: base64.RawURLEncoding.EncodeToString(authenticator.CredentialID),
+			Type:  "public-key",
+			Response: views.AuthenticatorResponseFields{
+				ClientDataJSON:    base64.RawURLEncoding.EncodeToString(attestation.ClientDataJSON),
+				AttestationObject: base64.RawURLEncoding.EncodeToString(attestation.AttestationObject),
+			},
+		},
+	}, client)
+	require.NoError(t, err)
+
+	// Login
+	login := func() (views.AuthResponse, error) {
+		requestResponse, err := passkeyUseCase.BeginPasskeyLogin(client)
+		require.NoError(t, err)
+
+		challenge, err := base64.RawURLEncoding.DecodeString(requestResponse.PublicKey.Challenge)
+		require.NoError(t, err)
+
+		assertion := authenticator.Get(rp.ID, rp.Origin, challenge)
+
+		return passkeyUseCase.FinishPasskeyLogin(views.PasskeyFinishRequest{
+			SessionID: requestResponse.SessionID,
+			Credential: views.PublicKeyCredential{
+				RawID: base64.RawURLEncoding.EncodeToString(authenticator.CredentialID),
+				Type:  "public-key",
+				Response: views.AuthenticatorResponseFields{
+					ClientDataJSON:    base64.RawURLEncoding.EncodeToString(assertion.ClientDataJSON),
+					AuthenticatorData: base64.RawURLEncoding.EncodeToString(assertion.AuthenticatorData),
+					Signature:         base64.RawURLEncoding.EncodeToString(assertion.Signature),
+				},
+			},
+		}, client)
+	}
+
+	authResponse, err = login()
+	require.NoError(t, err)
+	require.Equal(t, "Bearer", authResponse.TokenType)
+
+	// A failed attempt consumes the challenge
+	requestResponse, err := passkeyUseCase.BeginPasskeyLogin(client)
+	require.NoError(t, err)
+
+	challenge, err = base64.RawURLEncoding.DecodeString(requestResponse.PublicKey.Challenge)
+	require.NoError(t, err)
+
+	assertion := authenticator.Get(rp.ID, rp.Origin, challenge)
+
+	finish := func(signature []byte) error {
+		_, err := passkeyUseCase.FinishPasskeyLogin(views.PasskeyFinishRequest{
+			SessionID: requestResponse.SessionID,
+			Credential: views.PublicKeyCredential{
+				RawID: base64.RawURLEncoding.EncodeToString(authenticator.CredentialID),
+				Type:  "public-key",
+				Response: views.AuthenticatorResponseFields{
+					ClientDataJSON:    base64.RawURLEncoding.EncodeToString(assertion.ClientDataJSON),
+					AuthenticatorData: base64.RawURLEncoding.EncodeToString(assertion.AuthenticatorData),
+					Signature:         base64.RawURLEncoding.EncodeToString(signature),
+				},
+			},
+		}, client)
+
+		return err
+	}
+
+	var requestErr *errs.RequestError
+
+	err = finish(append([]byte{0}, assertion.Signature...))
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+
+	err = finish(assertion.Signature)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+
+	// A cloned authenticator replays an old counter
+	authenticator.SignCount = 0
+
+	_, err = login()
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+}
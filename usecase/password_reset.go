@@ -0,0 +1,292 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/mailer"
+	"github.com/flaambe/authservice/models"
+	"github.com/flaambe/authservice/password"
+	"github.com/flaambe/authservice/token"
+	"github.com/flaambe/authservice/views"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+const (
+	passwordResetPurpose  = "password_reset"
+	passwordResetDuration = 30 * time.Minute
+	verifyEmailPurpose    = "verify_email"
+	verifyEmailDuration   = 24 * time.Hour
+)
+
+// ForgotPassword mails a password reset link to the user with email. Like
+// RequestEmailLogin it succeeds for unknown addresses too and sends the mail
+// in the background.
+func (a *AuthUsecase) ForgotPassword(email string, client views.ClientInfo) error {
+	if a.mailer == nil {
+		return errs.New(http.StatusNotImplemented, "password reset is not configured", nil)
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return errs.New(http.StatusBadRequest, "email is invalid", err)
+	}
+
+	keys := a.mailThrottleKeys(client, address.Address)
+	reserved, err := a.reserveAttempt(keys)
+	if err != nil {
+		return err
+	}
+
+	a.recordRequest(reserved)
+	a.inBackground(func() error { return a.sendPasswordReset(address.Address) })
+
+	return nil
+}
+
+func (a *AuthUsecase) sendPasswordReset(address string) error {
+	userValue := models.User{}
+	filterByEmail := bson.M{"email": strings.ToLower(address)}
+
+	err := a.db.Collection("users").FindOne(context.Background(), filterByEmail).Decode(&userValue)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+
+	if err != nil {
+		return errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	if userValue.Disabled {
+		return nil
+	}
+
+	link, err := a.createPurposeLink(userValue, passwordResetPurpose, passwordResetDuration, a.passwordResetURL)
+	if err != nil {
+		return err
+	}
+
+	return a.sendMail(mailer.PasswordResetTemplate, userValue.Email, mailData{
+		Link:             link,
+		ExpiresInMinutes: int(passwordResetDuration.Minutes()),
+	})
+}
+
+// ResetPassword sets a new password with a token sent by ForgotPassword and
+// revokes all sessions of the user.
+func (a *AuthUsecase) ResetPassword(resetToken, newPassword string) error {
+	if len(newPassword) < minPasswordLength {
+		return errs.New(http.StatusBadRequest, "password is too short", nil)
+	}
+
+	users := a.db.Collection("users")
+
+	err := a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		userValue, err := a.consumePurposeToken(sctx, passwordResetPurpose, resetToken)
+		if err != nil {
+			return err
+		}
+
+		passwordHash, err := password.Hash(newPassword, a.passwordParams)
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		// Following the link proves control of the address as well.
+		userUpdate := bson.M{"$set": bson.M{"password_hash": passwordHash, "email_verified": true}}
+
+		_, err = users.UpdateOne(sctx, bson.M{"_id": userValue.ID}, userUpdate)
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		_, err = a.revokeTokens(sctx, models.EventUserLoggedOut, bson.M{"user_id": userValue.ID})
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return err
+		}
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// RequestEmailVerification mails a verification link to the address of the
+// authenticated user in the background.
+func (a *AuthUsecase) RequestEmailVerification(accessToken string, client views.ClientInfo) error {
+	if a.mailer == nil {
+		return errs.New(http.StatusNotImplemented, "email verification is not configured", nil)
+	}
+
+	var userValue models.User
+
+	err := a.useSession(func(sctx mongo.SessionContext) error {
+		var err error
+
+		_, userValue, err = a.authenticate(sctx, accessToken, client)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if userValue.Email == "" {
+		return errs.New(http.StatusBadRequest, "account has no email", nil)
+	}
+
+	if userValue.EmailVerified {
+		return errs.New(http.StatusConflict, "email already verified", nil)
+	}
+
+	keys := a.mailThrottleKeys(client, userValue.Email)
+	reserved, err := a.reserveAttempt(keys)
+	if err != nil {
+		return err
+	}
+
+	a.recordRequest(reserved)
+	a.inBackground(func() error { return a.sendVerificationEmail(userValue) })
+
+	return nil
+}
+
+// VerifyEmail marks the address of the user as verified with a token sent by
+// RequestEmailVerification.
+func (a *AuthUsecase) VerifyEmail(verifyToken string) error {
+	users := a.db.Collection("users")
+
+	err := a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		userValue, err := a.consumePurposeToken(sctx, verifyEmailPurpose, verifyToken)
+		if err != nil {
+			return err
+		}
+
+		_, err = users.UpdateOne(sctx, bson.M{"_id": userValue.ID}, bson.M{"$set": bson.M{"email_verified": true}})
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+func (a *AuthUsecase) sendVerificationEmail(user models.User) error {
+	link, err := a.createPurposeLink(user, verifyEmailPurpose, verifyEmailDuration, a.verifyEmailURL)
+	if err != nil {
+		return err
+	}
+
+	return a.sendMail(mailer.VerifyEmailTemplate, user.Email, mailData{
+		Link:             link,
+		ExpiresInMinutes: int(verifyEmailDuration.Minutes()),
+	})
+}
+
+// createPurposeLink issues a signed single-use token for purpose, replacing
+// any pending one, and returns it appended to baseURL, or alone when baseURL
+// is empty.
+func (a *AuthUsecase) createPurposeLink(user models.User, purpose string, ttl time.Duration, baseURL string) (string, error) {
+	_, err := a.db.Collection("one_time_codes").DeleteMany(context.Background(), bson.M{"user_id": user.ID, "purpose": purpose})
+	if err != nil {
+		return "", errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	jti := uuid.New().String()
+	if err := a.storeOneTimeToken(user.ID, purpose, jti, ttl); err != nil {
+		return "", err
+	}
+
+	purposeToken, err := a.signer.CreatePurposeToken(purpose, user.GUID, jti, ttl)
+	if err != nil {
+		return "", errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	if baseURL == "" {
+		return purposeToken, nil
+	}
+
+	return baseURL + "?" + url.Values{"token": {purposeToken}}.Encode(), nil
+}
+
+// consumePurposeToken verifies a token issued by createPurposeLink, removes
+// its one-time code and returns the user it was issued to.
+func (a *AuthUsecase) consumePurposeToken(sctx mongo.SessionContext, purpose, purposeToken string) (models.User, error) {
+	userValue := models.User{}
+
+	userGUID, jti, err := a.signer.ParsePurposeToken(purpose, purposeToken)
+	if err != nil {
+		return userValue, errs.New(http.StatusForbidden, "token invalid", err)
+	}
+
+	codeValue := models.OneTimeCode{}
+	filterByToken := bson.M{"purpose": purpose, "token_hash": token.HashSecret(jti)}
+
+	err = a.db.Collection("one_time_codes").FindOneAndDelete(sctx, filterByToken).Decode(&codeValue)
+	if err != nil {
+		return userValue, errs.New(http.StatusForbidden, "token invalid", err)
+	}
+
+	if codeValue.ExpiresAt.Time().Before(time.Now()) {
+		return userValue, errs.New(http.StatusForbidden, "token expired", nil)
+	}
+
+	err = a.db.Collection("users").FindOne(sctx, bson.M{"_id": codeValue.UserID}).Decode(&userValue)
+	if err != nil {
+		return userValue, errs.New(http.StatusForbidden, "token invalid", err)
+	}
+
+	if userValue.GUID != userGUID {
+		return userValue, errs.New(http.StatusForbidden, "token invalid", nil)
+	}
+
+	if userValue.Disabled {
+		return userValue, errs.New(http.StatusForbidden, "user is disabled", nil)
+	}
+
+	return userValue, nil
+}
@@ -0,0 +1,113 @@
+package usecase_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/mailer"
+	"github.com/flaambe/authservice/usecase"
+	"github.com/flaambe/authservice/views"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordReset(t *testing.T) {
+	memoryMailer := mailer.NewMemoryMailer()
+	resetUseCase, err := usecase.NewAuthUsecase(dbConfig.DB, signer,
+		usecase.WithMailer(memoryMailer),
+		usecase.WithPasswordResetURL("http://localhost:8080/reset"),
+	)
+	require.NoError(t, err)
+
+	_, err = resetUseCase.Register("grace", "grace@example.com", "correct horse")
+	require.NoError(t, err)
+
+	loginResponse, err := resetUseCase.Login("grace", "correct horse", client)
+	require.NoError(t, err)
+
+	// Same response for unknown addresses
+	err = resetUseCase.ForgotPassword("nobody@example.com", client)
+	require.NoError(t, err)
+
+	err = resetUseCase.ForgotPassword("grace@example.com", client)
+	require.NoError(t, err)
+
+	msg := waitForMail(t, memoryMailer, "grace@example.com", mailer.Message{})
+	require.Equal(t, "Reset your password", msg.Subject)
+
+	resetToken := tokenPattern.FindStringSubmatch(msg.Body)[1]
+
+	err = resetUseCase.ResetPassword(resetToken, "battery staple")
+	require.NoError(t, err)
+
+	// Existing sessions are revoked
+	var requestErr *errs.RequestError
+
+	_, err = resetUseCase.RefreshToken(loginResponse.AccessToken, loginResponse.RefreshToken, client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+
+	_, err = resetUseCase.Login("grace", "battery staple", client)
+	require.NoError(t, err)
+
+	// Tokens are single use
+	err = resetUseCase.ResetPassword(resetToken, "another password")
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+}
+
+func TestVerifyEmail(t *testing.T) {
+	memoryMailer := mailer.NewMemoryMailer()
+	verifyUseCase, err := usecase.NewAuthUsecase(dbConfig.DB, signer,
+		usecase.WithMailer(memoryMailer),
+		usecase.WithVerifyEmailURL("http://localhost:8080/verify"),
+	)
+	require.NoError(t, err)
+
+	_, err = verifyUseCase.Register("heidi", "heidi@example.com", "correct horse")
+	require.NoError(t, err)
+
+	// Registration sends the verification email
+	msg := waitForMail(t, memoryMailer, "heidi@example.com", mailer.Message{})
+	require.Equal(t, "Verify your email address", msg.Subject)
+
+	err = verifyUseCase.VerifyEmail(tokenPattern.FindStringSubmatch(msg.Body)[1])
+	require.NoError(t, err)
+
+	loginResponse, err := verifyUseCase.Login("heidi", "correct horse", client)
+	require.NoError(t, err)
+
+	var requestErr *errs.RequestError
+
+	err = verifyUseCase.RequestEmailVerification(loginResponse.AccessToken, client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusConflict, requestErr.Status)
+}
+
+func TestForgotPasswordThrottle(t *testing.T) {
+	throttledUseCase, err := usecase.NewAuthUsecase(dbConfig.DB, signer,
+		usecase.WithMailer(mailer.NewMemoryMailer()),
+		usecase.WithLockoutPolicy(usecase.LockoutPolicy{
+			AccountThreshold: 3,
+			IPThreshold:      10,
+			BaseDelay:        time.Minute,
+			MaxDelay:         time.Hour,
+			Window:           time.Hour,
+		}),
+	)
+	require.NoError(t, err)
+
+	// Requests are counted for unknown addresses too, across mail flows
+	for i := 0; i < 3; i++ {
+		err := throttledUseCase.RequestEmailLogin("nobody@example.org", views.ClientInfo{IP: "192.0.2.3"})
+		require.NoError(t, err)
+	}
+
+	var requestErr *errs.RequestError
+
+	err = throttledUseCase.ForgotPassword("nobody@example.org", views.ClientInfo{IP: "192.0.2.4"})
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusTooManyRequests, requestErr.Status)
+}
@@ -0,0 +1,178 @@
+package usecase
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/models"
+	"github.com/flaambe/authservice/views"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+)
+
+const revocationsCounter = "revocations"
+
+type counter struct {
+	Seq int64 `bson:"seq"`
+}
+
+// RevocationFeed returns the access tokens revoked after cursor since, or all
+// revoked and unexpired tokens when since is zero.
+func (a *AuthUsecase) RevocationFeed(since int64) (views.RevocationFeedResponse, error) {
+	var feedResponse views.RevocationFeedResponse
+
+	revoked := a.db.Collection("revoked_tokens")
+
+	err := a.useSession(func(sctx mongo.SessionContext) error {
+		feedResponse = views.RevocationFeedResponse{}
+
+		// A snapshot keeps the cursor consistent with the listed entries.
+		err := sctx.StartTransaction(options.Transaction().SetReadConcern(readconcern.Snapshot()))
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		defer sctx.AbortTransaction(sctx)
+
+		cursorValue := counter{}
+
+		err = a.db.Collection("counters").FindOne(sctx, bson.M{"_id": revocationsCounter}).Decode(&cursorValue)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		filter := bson.M{"expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())}}
+		if since > 0 && since <= cursorValue.Seq {
+			filter["seq"] = bson.M{"$gt": since}
+		} else {
+			feedResponse.Full = true
+		}
+
+		cursor, err := revoked.Find(sctx, filter, options.Find().SetSort(bson.M{"seq": 1}))
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		var revokedTokens []models.RevokedToken
+		if err := cursor.All(sctx, &revokedTokens); err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		feedResponse.Cursor = cursorValue.Seq
+		feedResponse.Revoked = make([]views.RevokedTokenResponse, 0, len(revokedTokens))
+
+		for _, r := range revokedTokens {
+			feedResponse.Revoked = append(feedResponse.Revoked, views.RevokedTokenResponse{
+				JTI:       r.JTI,
+				ExpiresAt: r.ExpiresAt.Time().Unix(),
+			})
+		}
+
+		return nil
+	})
+
+	return feedResponse, err
+}
+
+// revokeTokens deletes the token documents matching filter within the session
+// transaction, adds their still valid access tokens to the revocation list and
+// records eventType events for them. It returns the revoked tokens.
+func (a *AuthUsecase) revokeTokens(sctx mongo.SessionContext, eventType string, filter bson.M) ([]models.AuthToken, error) {
+	tokens := a.db.Collection("tokens")
+
+	cursor, err := tokens.Find(sctx, filter)
+	if err != nil {
+		return nil, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	var revokedTokens []models.AuthToken
+	if err := cursor.All(sctx, &revokedTokens); err != nil {
+		return nil, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	if len(revokedTokens) == 0 {
+		return nil, nil
+	}
+
+	ids := make(bson.A, 0, len(revokedTokens))
+	for _, t := range revokedTokens {
+		ids = append(ids, t.ID)
+	}
+
+	_, err = tokens.DeleteMany(sctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	if err := a.addRevocations(sctx, revokedTokens); err != nil {
+		return nil, err
+	}
+
+	if err := a.recordRevocationEvents(sctx, eventType, revokedTokens); err != nil {
+		return nil, err
+	}
+
+	return revokedTokens, nil
+}
+
+// addRevocations lists the unexpired access tokens of revokedTokens as revoked.
+func (a *AuthUsecase) addRevocations(sctx mongo.SessionContext, revokedTokens []models.AuthToken) error {
+	now := time.Now()
+
+	var entries []models.RevokedToken
+	for _, t := range revokedTokens {
+		if t.JTI == "" || t.AccessExpiresAt.Time().Before(now) {
+			continue
+		}
+
+		entries = append(entries, models.RevokedToken{
+			JTI:       t.JTI,
+			RevokedAt: primitive.NewDateTimeFromTime(now),
+			ExpiresAt: t.AccessExpiresAt,
+		})
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	first, err := a.reserveSeq(sctx, revocationsCounter, len(entries))
+	if err != nil {
+		return err
+	}
+
+	documents := make([]interface{}, 0, len(entries))
+	for i, entry := range entries {
+		entry.Seq = first + int64(i)
+		documents = append(documents, entry)
+	}
+
+	_, err = a.db.Collection("revoked_tokens").InsertMany(sctx, documents)
+	if err != nil {
+		return errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	return nil
+}
+
+// reserveSeq reserves n consecutive sequence numbers of the named counter and
+// returns the first one. Reserving writes the shared counter document, so
+// concurrent transactions commit in sequence order: all but the first fail
+// with a write conflict and are retried by useSession.
+func (a *AuthUsecase) reserveSeq(sctx mongo.SessionContext, name string, n int) (int64, error) {
+	counterValue := counter{}
+	opt := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	update := bson.M{"$inc": bson.M{"seq": int64(n)}}
+
+	err := a.db.Collection("counters").FindOneAndUpdate(sctx, bson.M{"_id": name}, update, opt).Decode(&counterValue)
+	if err != nil {
+		return 0, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	return counterValue.Seq - int64(n) + 1, nil
+}
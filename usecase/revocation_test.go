@@ -0,0 +1,120 @@
+package usecase_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/flaambe/authservice/views"
+	"github.com/stretchr/testify/require"
+)
+
+func tokenJTI(t *testing.T, accessToken string) string {
+	claims := jwt.MapClaims{}
+
+	_, _, err := new(jwt.Parser).ParseUnverified(accessToken, claims)
+	require.NoError(t, err)
+
+	jti, _ := claims["jti"].(string)
+	require.NotEmpty(t, jti)
+
+	return jti
+}
+
+func containsJTI(revoked []views.RevokedTokenResponse, jti string) bool {
+	for _, r := range revoked {
+		if r.JTI == jti {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestRevocationFeed(t *testing.T) {
+	snapshot, err := authUseCase.RevocationFeed(0)
+	require.NoError(t, err)
+	require.True(t, snapshot.Full)
+
+	first, err := authUseCase.Auth("4e5f6a7b-8c9d-4e0f-a1b2-c3d4e5f6a7b8", client)
+	require.NoError(t, err)
+
+	second, err := authUseCase.Auth("4e5f6a7b-8c9d-4e0f-a1b2-c3d4e5f6a7b8", client)
+	require.NoError(t, err)
+
+	// Refreshing revokes the replaced access token
+	refreshed, err := authUseCase.RefreshToken(first.AccessToken, first.RefreshToken, client)
+	require.NoError(t, err)
+
+	feed, err := authUseCase.RevocationFeed(snapshot.Cursor)
+	require.NoError(t, err)
+	require.False(t, feed.Full)
+	require.Len(t, feed.Revoked, 1)
+	require.Equal(t, tokenJTI(t, first.AccessToken), feed.Revoked[0].JTI)
+
+	err = authUseCase.DeleteAllTokens(second.AccessToken, client)
+	require.NoError(t, err)
+
+	update, err := authUseCase.RevocationFeed(feed.Cursor)
+	require.NoError(t, err)
+	require.False(t, update.Full)
+	require.Len(t, update.Revoked, 2)
+	require.True(t, containsJTI(update.Revoked, tokenJTI(t, second.AccessToken)))
+	require.True(t, containsJTI(update.Revoked, tokenJTI(t, refreshed.AccessToken)))
+
+	// An unknown cursor falls back to a snapshot
+	full, err := authUseCase.RevocationFeed(update.Cursor + 100)
+	require.NoError(t, err)
+	require.True(t, full.Full)
+	require.Equal(t, update.Cursor, full.Cursor)
+	require.True(t, containsJTI(full.Revoked, tokenJTI(t, first.AccessToken)))
+}
+
+func TestConcurrentRevocations(t *testing.T) {
+	guids := []string{
+		"5a6b7c8d-9e0f-4a1b-8c2d-3e4f5a6b7c8d",
+		"6b7c8d9e-0f1a-4b2c-9d3e-4f5a6b7c8d9e",
+		"7c8d9e0f-1a2b-4c3d-8e4f-5a6b7c8d9e0f",
+		"8d9e0f1a-2b3c-4d4e-9f5a-6b7c8d9e0f1a",
+		"9e0f1a2b-3c4d-4e5f-8a6b-7c8d9e0f1a2b",
+		"0f1a2b3c-4d5e-4f6a-9b7c-8d9e0f1a2b3c",
+	}
+
+	snapshot, err := authUseCase.RevocationFeed(0)
+	require.NoError(t, err)
+
+	var sessions []views.AuthResponse
+	for _, guid := range guids {
+		authResponse, err := authUseCase.Auth(guid, client)
+		require.NoError(t, err)
+
+		sessions = append(sessions, authResponse)
+	}
+
+	// Every refresh reserves a sequence number from the same counter
+	errs := make([]error, len(sessions))
+
+	var wg sync.WaitGroup
+	for i, session := range sessions {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, errs[i] = authUseCase.RefreshToken(session.AccessToken, session.RefreshToken, client)
+		}()
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	feed, err := authUseCase.RevocationFeed(snapshot.Cursor)
+	require.NoError(t, err)
+
+	for _, session := range sessions {
+		require.True(t, containsJTI(feed.Revoked, tokenJTI(t, session.AccessToken)))
+	}
+}
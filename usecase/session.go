@@ -0,0 +1,137 @@
+package usecase
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/models"
+	"github.com/flaambe/authservice/views"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+const maxSessionNameLength = 128
+
+// ListSessions returns the active sessions of the user owning accessToken,
+// most recent first.
+func (a *AuthUsecase) ListSessions(accessToken string, client views.ClientInfo) ([]views.SessionResponse, error) {
+	var sessions []views.SessionResponse
+
+	tokens := a.db.Collection("tokens")
+
+	err := a.useSession(func(sctx mongo.SessionContext) error {
+		tokenValue, _, err := a.authenticate(sctx, accessToken, client)
+		if err != nil {
+			return err
+		}
+
+		filter := bson.M{
+			"user_id":            tokenValue.UserID,
+			"refresh_expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+		}
+		opt := options.Find().SetSort(bson.M{"created_at": -1})
+
+		cursor, err := tokens.Find(sctx, filter, opt)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		var sessionTokens []models.AuthToken
+		if err := cursor.All(sctx, &sessionTokens); err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		sessions = make([]views.SessionResponse, 0, len(sessionTokens))
+		for _, t := range sessionTokens {
+			session := newSessionResponse(t)
+			session.Current = t.ID == tokenValue.ID
+			sessions = append(sessions, session)
+		}
+
+		return nil
+	})
+
+	return sessions, err
+}
+
+// RevokeSession deletes the session with sessionID of the user owning
+// accessToken, which may be the current one.
+func (a *AuthUsecase) RevokeSession(accessToken, sessionID string, client views.ClientInfo) error {
+	id, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return errs.New(http.StatusNotFound, "session not found", err)
+	}
+
+	err = a.useSession(func(sctx mongo.SessionContext) error {
+		err := sctx.StartTransaction(options.Transaction().
+			SetReadConcern(readconcern.Snapshot()).
+			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
+		)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		tokenValue, _, err := a.authenticate(sctx, accessToken, client)
+		if err != nil {
+			return err
+		}
+
+		revoked, err := a.revokeTokens(sctx, models.EventSessionRevoked, bson.M{"_id": id, "user_id": tokenValue.UserID})
+		if err != nil {
+			sctx.AbortTransaction(sctx)
+			return err
+		}
+
+		if len(revoked) == 0 {
+			sctx.AbortTransaction(sctx)
+			return errs.New(http.StatusNotFound, "session not found", nil)
+		}
+
+		err = sctx.CommitTransaction(sctx)
+		if err != nil {
+			return errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+func newSessionResponse(t models.AuthToken) views.SessionResponse {
+	session := views.SessionResponse{
+		ID:        t.ID.Hex(),
+		Name:      t.Name,
+		UserAgent: t.UserAgent,
+		ClientIP:  t.ClientIP,
+		CreatedAt: t.CreatedAt.Time().UTC(),
+		ExpiresAt: t.RefreshExpiresAt.Time().UTC(),
+	}
+
+	if t.LastRefreshedAt != 0 {
+		lastRefreshedAt := t.LastRefreshedAt.Time().UTC()
+		session.LastRefreshedAt = &lastRefreshedAt
+	}
+
+	return session
+}
+
+// sessionName is the name the client chose for the session, or its user agent.
+func sessionName(client views.ClientInfo) string {
+	name := client.SessionName
+	if name == "" {
+		name = client.UserAgent
+	}
+
+	if len(name) > maxSessionNameLength {
+		name = name[:maxSessionNameLength]
+	}
+
+	return name
+}
@@ -0,0 +1,114 @@
+package usecase
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/models"
+	"github.com/flaambe/authservice/views"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SessionLimitAction decides what happens to a login over the session limit.
+type SessionLimitAction int
+
+const (
+	// RejectNewSession fails the login.
+	RejectNewSession SessionLimitAction = iota
+	// EvictOldestSession revokes the oldest sessions to make room.
+	EvictOldestSession
+)
+
+// SessionLimits caps the number of active sessions of a user, in total and per
+// client, a client being identified by its user agent. Zero means unlimited.
+type SessionLimits struct {
+	MaxPerUser   int
+	MaxPerClient int
+	Action       SessionLimitAction
+}
+
+// enforceSessionLimits makes room for one more session of user from client
+// within the session transaction, or rejects it. The user document is written
+// so that concurrent logins of the same user conflict instead of both passing
+// the check.
+func (a *AuthUsecase) enforceSessionLimits(sctx mongo.SessionContext, user models.User, client views.ClientInfo) error {
+	limits := a.sessionLimits
+	if limits.MaxPerUser <= 0 && limits.MaxPerClient <= 0 {
+		return nil
+	}
+
+	_, err := a.db.Collection("users").UpdateOne(sctx, bson.M{"_id": user.ID}, bson.M{"$inc": bson.M{"session_version": 1}})
+	if err != nil {
+		return errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	active := bson.M{
+		"user_id":            user.ID,
+		"refresh_expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+	}
+
+	if limits.MaxPerUser > 0 {
+		if err := a.limitSessions(sctx, active, limits.MaxPerUser); err != nil {
+			return err
+		}
+	}
+
+	if limits.MaxPerClient > 0 {
+		activeForClient := bson.M{}
+		for k, v := range active {
+			activeForClient[k] = v
+		}
+
+		activeForClient["user_agent"] = client.UserAgent
+
+		if err := a.limitSessions(sctx, activeForClient, limits.MaxPerClient); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *AuthUsecase) limitSessions(sctx mongo.SessionContext, filter bson.M, max int) error {
+	tokens := a.db.Collection("tokens")
+
+	count, err := tokens.CountDocuments(sctx, filter)
+	if err != nil {
+		return errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	excess := count - int64(max) + 1
+	if excess <= 0 {
+		return nil
+	}
+
+	if a.sessionLimits.Action == RejectNewSession {
+		return errs.New(http.StatusConflict, "session limit reached", nil)
+	}
+
+	opt := options.Find().SetSort(bson.M{"created_at": 1}).SetLimit(excess).SetProjection(bson.M{"_id": 1})
+
+	cursor, err := tokens.Find(sctx, filter, opt)
+	if err != nil {
+		return errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	var oldest []models.AuthToken
+	if err := cursor.All(sctx, &oldest); err != nil {
+		return errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	ids := make(bson.A, 0, len(oldest))
+	for _, t := range oldest {
+		ids = append(ids, t.ID)
+	}
+
+	_, err = a.revokeTokens(sctx, models.EventSessionRevoked, bson.M{"_id": bson.M{"$in": ids}})
+
+	return err
+}
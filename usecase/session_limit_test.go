@@ -0,0 +1,63 @@
+package usecase_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/usecase"
+	"github.com/flaambe/authservice/views"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionLimitReject(t *testing.T) {
+	limitedUseCase, err := usecase.NewAuthUsecase(dbConfig.DB, signer, usecase.WithSessionLimits(usecase.SessionLimits{
+		MaxPerUser: 2,
+		Action:     usecase.RejectNewSession,
+	}))
+	require.NoError(t, err)
+
+	guid := "0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+
+	for i := 0; i < 2; i++ {
+		_, err := limitedUseCase.Auth(guid, client)
+		require.NoError(t, err)
+	}
+
+	var requestErr *errs.RequestError
+
+	_, err = limitedUseCase.Auth(guid, client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusConflict, requestErr.Status)
+}
+
+func TestSessionLimitEvictOldest(t *testing.T) {
+	limitedUseCase, err := usecase.NewAuthUsecase(dbConfig.DB, signer, usecase.WithSessionLimits(usecase.SessionLimits{
+		MaxPerUser:   3,
+		MaxPerClient: 1,
+		Action:       usecase.EvictOldestSession,
+	}))
+	require.NoError(t, err)
+
+	guid := "1b2c3d4e-5f6a-4b7c-9d8e-0f1a2b3c4d5e"
+	desktop := views.ClientInfo{UserAgent: "desktop"}
+	mobile := views.ClientInfo{UserAgent: "mobile"}
+
+	first, err := limitedUseCase.Auth(guid, desktop)
+	require.NoError(t, err)
+
+	_, err = limitedUseCase.Auth(guid, mobile)
+	require.NoError(t, err)
+
+	// A second desktop session replaces the first one
+	second, err := limitedUseCase.Auth(guid, desktop)
+	require.NoError(t, err)
+
+	_, err = limitedUseCase.RefreshToken(first.AccessToken, first.RefreshToken, desktop)
+	require.Error(t, err)
+
+	sessions, err := limitedUseCase.ListSessions(second.AccessToken, desktop)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+}
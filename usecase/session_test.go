@@ -0,0 +1,58 @@
+package usecase_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/views"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessions(t *testing.T) {
+	laptop := views.ClientInfo{IP: "198.51.100.1", UserAgent: "Mozilla/5.0 (X11; Linux x86_64)", SessionName: "laptop"}
+	phone := views.ClientInfo{IP: "198.51.100.2", UserAgent: "Mozilla/5.0 (iPhone)"}
+
+	laptopAuth, err := authUseCase.Auth("7d3c2b1a-0f9e-4d8c-b7a6-5e4d3c2b1a0f", laptop)
+	require.NoError(t, err)
+
+	phoneAuth, err := authUseCase.Auth("7d3c2b1a-0f9e-4d8c-b7a6-5e4d3c2b1a0f", phone)
+	require.NoError(t, err)
+
+	_, err = authUseCase.RefreshToken(phoneAuth.AccessToken, phoneAuth.RefreshToken, phone)
+	require.NoError(t, err)
+
+	sessions, err := authUseCase.ListSessions(laptopAuth.AccessToken, laptop)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+
+	var laptopSession, phoneSession views.SessionResponse
+	for _, s := range sessions {
+		if s.Current {
+			laptopSession = s
+		} else {
+			phoneSession = s
+		}
+	}
+
+	require.Equal(t, "laptop", laptopSession.Name)
+	require.Equal(t, laptop.IP, laptopSession.ClientIP)
+	require.Nil(t, laptopSession.LastRefreshedAt)
+	require.Equal(t, phone.UserAgent, phoneSession.Name)
+	require.NotNil(t, phoneSession.LastRefreshedAt)
+
+	// Revoke the phone session from the laptop
+	err = authUseCase.RevokeSession(laptopAuth.AccessToken, phoneSession.ID, laptop)
+	require.NoError(t, err)
+
+	sessions, err = authUseCase.ListSessions(laptopAuth.AccessToken, laptop)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+
+	var requestErr *errs.RequestError
+
+	err = authUseCase.RevokeSession(laptopAuth.AccessToken, phoneSession.ID, laptop)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusNotFound, requestErr.Status)
+}
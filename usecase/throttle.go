@@ -0,0 +1,268 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/models"
+	"github.com/flaambe/authservice/token"
+	"github.com/flaambe/authservice/views"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LockoutPolicy controls how authentication failures are throttled. Once an
+// account or IP reaches its threshold, every further failure locks it for
+// BaseDelay doubled per failure over the threshold, up to MaxDelay. Counters
+// are forgotten after Window without failures. A zero AccountThreshold
+// disables throttling.
+type LockoutPolicy struct {
+	AccountThreshold int
+	IPThreshold      int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	Window           time.Duration
+}
+
+var DefaultLockoutPolicy = LockoutPolicy{
+	AccountThreshold: 5,
+	IPThreshold:      20,
+	BaseDelay:        30 * time.Second,
+	MaxDelay:         time.Hour,
+	Window:           24 * time.Hour,
+}
+
+type throttleKey struct {
+	key       string
+	threshold int
+	account   bool
+}
+
+// throttleKeys returns the counters an attempt on account from client is
+// tracked under. An empty account only tracks the IP.
+func (a *AuthUsecase) throttleKeys(client views.ClientInfo, account string) []throttleKey {
+	var keys []throttleKey
+
+	if account != "" {
+		keys = append(keys, throttleKey{"account:" + token.HashSecret(account), a.lockoutPolicy.AccountThreshold, true})
+	}
+
+	if client.IP != "" {
+		keys = append(keys, throttleKey{"ip:" + client.IP, a.lockoutPolicy.IPThreshold, false})
+	}
+
+	return keys
+}
+
+// loginAccount returns the account an attempt with login is counted against:
+// the GUID of its user, so that the username and the email of an account
+// share one counter, or the normalised login when there is no such user.
+func (a *AuthUsecase) loginAccount(login string) (string, error) {
+	userValue := models.User{}
+
+	err := a.db.Collection("users").FindOne(context.Background(), loginFilter(login)).Decode(&userValue)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "login:" + strings.ToLower(strings.TrimSpace(login)), nil
+	}
+
+	if err != nil {
+		return "", errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	return userValue.GUID, nil
+}
+
+// reservedKey is a counter incremented by reserveAttempt.
+type reservedKey struct {
+	throttleKey
+	failures int
+	// claimed is set when the attempt was let past the threshold by replacing
+	// the expired lock previousLock with a new one.
+	claimed      bool
+	previousLock primitive.DateTime
+}
+
+// reserveAttempt counts an attempt against keys before it is made, so that
+// concurrent attempts can not all pass while none of their failures is
+// recorded yet. It rejects the attempt, releasing its reservation, while any
+// of keys is locked. Past its threshold a key lets a single attempt through
+// once its lock expires and is locked again meanwhile.
+func (a *AuthUsecase) reserveAttempt(keys []throttleKey) ([]reservedKey, error) {
+	if len(keys) == 0 || a.lockoutPolicy.AccountThreshold <= 0 {
+		return nil, nil
+	}
+
+	attempts := a.db.Collection("login_attempts")
+	now := time.Now()
+	opt := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var reserved []reservedKey
+
+	for _, k := range keys {
+		update := bson.M{
+			"$inc": bson.M{"failures": 1},
+			"$set": bson.M{"expires_at": primitive.NewDateTimeFromTime(now.Add(a.lockoutPolicy.Window))},
+		}
+
+		value := models.LoginAttempts{}
+		if err := attempts.FindOneAndUpdate(context.Background(), bson.M{"_id": k.key}, update, opt).Decode(&value); err != nil {
+			a.releaseAttempt(reserved)
+			return nil, errs.New(http.StatusInternalServerError, "server internal error", err)
+		}
+
+		reserved = append(reserved, reservedKey{throttleKey: k, failures: value.Failures})
+
+		retryAfter := value.LockedUntil.Time().Sub(now)
+		if retryAfter <= 0 && value.Failures > k.threshold {
+			retryAfter = a.claimLock(&reserved[len(reserved)-1], value.LockedUntil, now)
+		}
+
+		if retryAfter > 0 {
+			a.releaseAttempt(reserved)
+			return nil, errs.NewRetryAfter("too many failed attempts", retryAfter)
+		}
+	}
+
+	return reserved, nil
+}
+
+// claimLock replaces the expired lock of a key past its threshold with the one
+// its next failure would set, so that only one attempt at a time gets
+// through. It returns how long to wait when another attempt got there first
+// or when failures below the threshold are still in progress.
+func (a *AuthUsecase) claimLock(r *reservedKey, lockedUntil primitive.DateTime, now time.Time) time.Duration {
+	if lockedUntil == 0 {
+		return a.lockoutPolicy.BaseDelay
+	}
+
+	delay := a.lockDelay(r.threshold, r.failures)
+
+	filter := bson.M{"_id": r.key, "locked_until": lockedUntil}
+	lock := bson.M{"$set": bson.M{"locked_until": primitive.NewDateTimeFromTime(now.Add(delay))}}
+
+	result, err := a.db.Collection("login_attempts").UpdateOne(context.Background(), filter, lock)
+	if err != nil {
+		slog.Error("Updating login attempts failed", "err", err)
+		return delay
+	}
+
+	if result.MatchedCount == 0 {
+		return delay
+	}
+
+	r.claimed = true
+	r.previousLock = lockedUntil
+
+	return 0
+}
+
+// recordAttempt settles the counters reserved for an attempt with its
+// outcome. Authentication failures are kept, locking the counters that
+// reached their threshold, success clears the account counter, and any other
+// outcome releases the reservation. Errors are only logged, the outcome of
+// the attempt stands either way.
+func (a *AuthUsecase) recordAttempt(reserved []reservedKey, err error) {
+	if err == nil {
+		var others []reservedKey
+
+		for _, r := range reserved {
+			if !r.account {
+				others = append(others, r)
+				continue
+			}
+
+			if _, err := a.db.Collection("login_attempts").DeleteOne(context.Background(), bson.M{"_id": r.key}); err != nil {
+				slog.Error("Updating login attempts failed", "err", err)
+			}
+		}
+
+		a.releaseAttempt(others)
+
+		return
+	}
+
+	var requestErr *errs.RequestError
+	if !errors.As(err, &requestErr) || (requestErr.Status != http.StatusUnauthorized && requestErr.Status != http.StatusForbidden) {
+		a.releaseAttempt(reserved)
+		return
+	}
+
+	a.lockReserved(reserved)
+}
+
+// mailThrottleKeys returns the counters a request from client to mail
+// address is tracked under. They are separate from the login counters, so
+// that requesting mail does not lock out logins from the same IP.
+func (a *AuthUsecase) mailThrottleKeys(client views.ClientInfo, address string) []throttleKey {
+	keys := a.throttleKeys(client, "mail:"+strings.ToLower(address))
+	for i := range keys {
+		if !keys[i].account {
+			keys[i].key = "mail:" + keys[i].key
+		}
+	}
+
+	return keys
+}
+
+// recordRequest keeps the counters reserved for a request that sends mail,
+// whatever its outcome, so that addresses can not be flooded with messages.
+func (a *AuthUsecase) recordRequest(reserved []reservedKey) {
+	a.lockReserved(reserved)
+}
+
+// releaseAttempt takes back the reservation of an attempt that is not
+// counted, restoring the lock it claimed.
+func (a *AuthUsecase) releaseAttempt(reserved []reservedKey) {
+	for _, r := range reserved {
+		update := bson.M{"$inc": bson.M{"failures": -1}}
+		if r.claimed {
+			update["$set"] = bson.M{"locked_until": r.previousLock}
+		}
+
+		if _, err := a.db.Collection("login_attempts").UpdateOne(context.Background(), bson.M{"_id": r.key}, update); err != nil {
+			slog.Error("Updating login attempts failed", "err", err)
+		}
+	}
+}
+
+// lockReserved locks the reserved counters that reached their threshold,
+// unless the attempt already holds a lock.
+func (a *AuthUsecase) lockReserved(reserved []reservedKey) {
+	now := time.Now()
+
+	for _, r := range reserved {
+		if r.claimed || r.failures < r.threshold {
+			continue
+		}
+
+		until := primitive.NewDateTimeFromTime(now.Add(a.lockDelay(r.threshold, r.failures)))
+		lock := bson.M{"$max": bson.M{"locked_until": until}}
+
+		if _, err := a.db.Collection("login_attempts").UpdateOne(context.Background(), bson.M{"_id": r.key}, lock); err != nil {
+			slog.Error("Updating login attempts failed", "err", err)
+		}
+	}
+}
+
+// lockDelay returns how long a counter with failures is locked for, doubling
+// BaseDelay per failure over threshold up to MaxDelay.
+func (a *AuthUsecase) lockDelay(threshold, failures int) time.Duration {
+	delay := a.lockoutPolicy.BaseDelay
+	for i := threshold; i < failures && delay < a.lockoutPolicy.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > a.lockoutPolicy.MaxDelay {
+		delay = a.lockoutPolicy.MaxDelay
+	}
+
+	return delay
+}
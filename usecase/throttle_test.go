@@ -0,0 +1,131 @@
+package usecase_test
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/usecase"
+	"github.com/flaambe/authservice/views"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockout(t *testing.T) {
+	throttledUseCase, err := usecase.NewAuthUsecase(dbConfig.DB, signer, usecase.WithLockoutPolicy(usecase.LockoutPolicy{
+		AccountThreshold: 3,
+		IPThreshold:      10,
+		BaseDelay:        time.Minute,
+		MaxDelay:         time.Hour,
+		Window:           time.Hour,
+	}))
+	require.NoError(t, err)
+
+	_, err = throttledUseCase.Register("ivan", "ivan@example.com", "correct horse")
+	require.NoError(t, err)
+
+	attacker := views.ClientInfo{IP: "192.0.2.1"}
+
+	var requestErr *errs.RequestError
+
+	for i := 0; i < 3; i++ {
+		_, err = throttledUseCase.Login("ivan", "wrong password", attacker)
+		require.True(t, errors.As(err, &requestErr))
+		require.Equal(t, http.StatusUnauthorized, requestErr.Status)
+	}
+
+	// The account is locked even for the right password and another IP
+	_, err = throttledUseCase.Login("ivan", "correct horse", views.ClientInfo{IP: "192.0.2.2"})
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusTooManyRequests, requestErr.Status)
+	require.True(t, requestErr.RetryAfter > 0 && requestErr.RetryAfter <= time.Minute)
+
+	// The username and the email of an account share one counter
+	_, err = throttledUseCase.Login("ivan@example.com", "correct horse", views.ClientInfo{IP: "192.0.2.2"})
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusTooManyRequests, requestErr.Status)
+
+	// Other accounts from the attacker IP are allowed until its threshold
+	_, err = throttledUseCase.Register("judy", "judy@example.com", "correct horse")
+	require.NoError(t, err)
+
+	_, err = throttledUseCase.Login("judy", "correct horse", attacker)
+	require.NoError(t, err)
+}
+
+func TestLockoutConcurrent(t *testing.T) {
+	throttledUseCase, err := usecase.NewAuthUsecase(dbConfig.DB, signer, usecase.WithLockoutPolicy(usecase.LockoutPolicy{
+		AccountThreshold: 3,
+		IPThreshold:      100,
+		BaseDelay:        time.Minute,
+		MaxDelay:         time.Hour,
+		Window:           time.Hour,
+	}))
+	require.NoError(t, err)
+
+	_, err = throttledUseCase.Register("mallory", "mallory@example.com", "correct horse")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var guesses int
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := throttledUseCase.Login("mallory", "wrong password", views.ClientInfo{IP: "192.0.2.5"})
+
+			var requestErr *errs.RequestError
+			if errors.As(err, &requestErr) && requestErr.Status == http.StatusUnauthorized {
+				mu.Lock()
+				guesses++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// Attempts are counted when they start, so concurrent guesses can not
+	// get past the threshold
+	require.LessOrEqual(t, guesses, 3)
+}
+
+func TestConfirmTOTPThrottle(t *testing.T) {
+	throttledUseCase, err := usecase.NewAuthUsecase(dbConfig.DB, signer, usecase.WithLockoutPolicy(usecase.LockoutPolicy{
+		AccountThreshold: 3,
+		IPThreshold:      10,
+		BaseDelay:        time.Minute,
+		MaxDelay:         time.Hour,
+		Window:           time.Hour,
+	}))
+	require.NoError(t, err)
+
+	_, err = throttledUseCase.Register("oscar", "oscar@example.com", "correct horse")
+	require.NoError(t, err)
+
+	client := views.ClientInfo{IP: "192.0.2.6"}
+
+	loginResponse, err := throttledUseCase.Login("oscar", "correct horse", client)
+	require.NoError(t, err)
+
+	_, err = throttledUseCase.EnrollTOTP(loginResponse.AccessToken, client)
+	require.NoError(t, err)
+
+	var requestErr *errs.RequestError
+
+	for i := 0; i < 3; i++ {
+		_, err = throttledUseCase.ConfirmTOTP(loginResponse.AccessToken, "000000x", client)
+		require.True(t, errors.As(err, &requestErr))
+		require.Equal(t, http.StatusForbidden, requestErr.Status)
+	}
+
+	_, err = throttledUseCase.ConfirmTOTP(loginResponse.AccessToken, "000000x", client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusTooManyRequests, requestErr.Status)
+}
@@ -0,0 +1,69 @@
+package usecase
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/views"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Verify checks the signature, expiry and revocation of accessToken and that
+// it carries the roles and scopes of req. A token bound to a client
+// certificate is only valid when presented with that certificate. It only
+// reads the revocation list, so it is cheap enough to run on every proxied
+// request.
+func (a *AuthUsecase) Verify(accessToken string, req views.VerifyRequest) (views.VerifyResponse, error) {
+	var verifyResponse views.VerifyResponse
+
+	claims, err := a.signer.ParseAccessToken(accessToken)
+	if err != nil {
+		return verifyResponse, errs.New(http.StatusUnauthorized, "access token is invalid", nil)
+	}
+
+	if claims.CertThumbprint != "" && claims.CertThumbprint != req.CertThumbprint {
+		return verifyResponse, errs.New(http.StatusUnauthorized, "access token is bound to another certificate", nil)
+	}
+
+	opt := options.FindOne().SetProjection(bson.M{"_id": 1})
+
+	err = a.db.Collection("revoked_tokens").FindOne(context.Background(), bson.M{"jti": claims.JTI}, opt).Err()
+	if err == nil {
+		return verifyResponse, errs.New(http.StatusUnauthorized, "access token is revoked", nil)
+	}
+
+	if err != mongo.ErrNoDocuments {
+		return verifyResponse, errs.New(http.StatusInternalServerError, "server internal error", err)
+	}
+
+	for _, role := range req.Roles {
+		if !containsString(claims.Roles, role) {
+			return verifyResponse, errs.New(http.StatusForbidden, "missing role "+role, nil)
+		}
+	}
+
+	for _, scope := range req.Scopes {
+		if !containsString(claims.Scopes, scope) {
+			return verifyResponse, errs.New(http.StatusForbidden, "missing scope "+scope, nil)
+		}
+	}
+
+	verifyResponse = views.VerifyResponse{
+		UserGUID:  claims.UserGUID,
+		Roles:     claims.Roles,
+		Scopes:    claims.Scopes,
+		AMR:       claims.AMR,
+		ExpiresIn: int(time.Until(claims.ExpiresAt).Seconds()),
+	}
+
+	if claims.CertThumbprint != "" {
+		verifyResponse.Cnf = &views.Confirmation{CertThumbprint: claims.CertThumbprint}
+	}
+
+	return verifyResponse, nil
+}
@@ -0,0 +1,102 @@
+package usecase_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/flaambe/authservice/errs"
+	"github.com/flaambe/authservice/views"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	userResponse, err := authUseCase.CreateUser("")
+	require.NoError(t, err)
+
+	_, err = authUseCase.SetUserGrants(userResponse.GUID, []string{"staff"}, []string{"reports:read"})
+	require.NoError(t, err)
+
+	authResponse, err := authUseCase.Auth(userResponse.GUID, client)
+	require.NoError(t, err)
+
+	verifyResponse, err := authUseCase.Verify(authResponse.AccessToken, views.VerifyRequest{
+		Roles:  []string{"staff"},
+		Scopes: []string{"reports:read"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, userResponse.GUID, verifyResponse.UserGUID)
+	require.Equal(t, []string{"staff"}, verifyResponse.Roles)
+
+	var requestErr *errs.RequestError
+
+	_, err = authUseCase.Verify(authResponse.AccessToken, views.VerifyRequest{Roles: []string{"admin"}})
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+
+	_, err = authUseCase.Verify(authResponse.AccessToken+"x", views.VerifyRequest{})
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusUnauthorized, requestErr.Status)
+
+	// Revoked tokens no longer verify
+	err = authUseCase.DeleteToken(authResponse.AccessToken, authResponse.RefreshToken, client)
+	require.NoError(t, err)
+
+	_, err = authUseCase.Verify(authResponse.AccessToken, views.VerifyRequest{})
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusUnauthorized, requestErr.Status)
+}
+
+func TestVerifyCertificateBound(t *testing.T) {
+	userResponse, err := authUseCase.CreateUser("")
+	require.NoError(t, err)
+
+	mtlsClient := client
+	mtlsClient.CertThumbprint = "bwcK0esc3ACC3DB2Y5_lESsXE8o9ltc05O89jdN-dg2"
+
+	authResponse, err := authUseCase.Auth(userResponse.GUID, mtlsClient)
+	require.NoError(t, err)
+
+	verifyResponse, err := authUseCase.Verify(authResponse.AccessToken, views.VerifyRequest{CertThumbprint: mtlsClient.CertThumbprint})
+	require.NoError(t, err)
+	require.Equal(t, mtlsClient.CertThumbprint, verifyResponse.Cnf.CertThumbprint)
+
+	var requestErr *errs.RequestError
+
+	// Presented without the certificate, or with another one
+	for _, thumbprint := range []string{"", "Y5_lESsXE8o9ltc05O89jdN-dg2bwcK0esc3ACC3DB2"} {
+		_, err = authUseCase.Verify(authResponse.AccessToken, views.VerifyRequest{CertThumbprint: thumbprint})
+		require.True(t, errors.As(err, &requestErr))
+		require.Equal(t, http.StatusUnauthorized, requestErr.Status)
+	}
+
+	// Only the client holding the certificate can refresh the tokens
+	_, err = authUseCase.RefreshToken(authResponse.AccessToken, authResponse.RefreshToken, client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+
+	refreshResponse, err := authUseCase.RefreshToken(authResponse.AccessToken, authResponse.RefreshToken, mtlsClient)
+	require.NoError(t, err)
+
+	_, err = authUseCase.Verify(refreshResponse.AccessToken, views.VerifyRequest{CertThumbprint: mtlsClient.CertThumbprint})
+	require.NoError(t, err)
+
+	// and use them on the service itself
+	_, err = authUseCase.ListSessions(refreshResponse.AccessToken, client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+
+	err = authUseCase.DeleteToken(refreshResponse.AccessToken, refreshResponse.RefreshToken, client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+
+	err = authUseCase.DeleteAllTokens(refreshResponse.AccessToken, client)
+	require.True(t, errors.As(err, &requestErr))
+	require.Equal(t, http.StatusForbidden, requestErr.Status)
+
+	_, err = authUseCase.ListSessions(refreshResponse.AccessToken, mtlsClient)
+	require.NoError(t, err)
+
+	err = authUseCase.DeleteAllTokens(refreshResponse.AccessToken, mtlsClient)
+	require.NoError(t, err)
+}
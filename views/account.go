@@ -0,0 +1,53 @@
+package views
+
+type RegisterRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type RegisterResponse struct {
+	GUID     string `json:"guid"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type LoginRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+// LoginResponse carries either the token pair or, when the user has a second
+// factor enabled, the MFA challenge to complete with /login/mfa.
+type LoginResponse struct {
+	*AuthResponse
+	*MFAChallengeResponse
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type EmailLoginRequest struct {
+	Email string `json:"email"`
+}
+
+type EmailLoginRedeemRequest struct {
+	Token string `json:"token"`
+	Email string `json:"email"`
+	Code  string `json:"code"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
@@ -0,0 +1,52 @@
+package views
+
+type CreateUserRequest struct {
+	GUID string `json:"guid"`
+}
+
+type UserResponse struct {
+	GUID     string   `json:"guid"`
+	Username string   `json:"username,omitempty"`
+	Email    string   `json:"email,omitempty"`
+	Disabled bool     `json:"disabled"`
+	Roles    []string `json:"roles,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+
+	EmailVerified bool `json:"email_verified"`
+	TOTPEnabled   bool `json:"totp_enabled"`
+}
+
+// UserSearchRequest filters users by Query, matched against the GUID or a
+// username or email prefix, and by Disabled when set.
+type UserSearchRequest struct {
+	Query    string
+	Disabled *bool
+	Limit    int64
+	Offset   int64
+}
+
+type UserListResponse struct {
+	Users []UserResponse `json:"users"`
+	Total int64          `json:"total"`
+}
+
+type StatsResponse struct {
+	Users              int64 `json:"users"`
+	DisabledUsers      int64 `json:"disabled_users"`
+	ActiveSessions     int64 `json:"active_sessions"`
+	ActiveAccessTokens int64 `json:"active_access_tokens"`
+	RevokedTokens      int64 `json:"revoked_tokens"`
+}
+
+type UserGrantsRequest struct {
+	Roles  []string `json:"roles"`
+	Scopes []string `json:"scopes"`
+}
+
+// PurgeResponse counts the expired documents deleted by a purge.
+type PurgeResponse struct {
+	Tokens             int64 `json:"tokens"`
+	RevokedTokens      int64 `json:"revoked_tokens"`
+	OneTimeCodes       int64 `json:"one_time_codes"`
+	WebAuthnChallenges int64 `json:"webauthn_challenges"`
+}
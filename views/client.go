@@ -0,0 +1,11 @@
+package views
+
+// ClientInfo describes the client a request came from.
+type ClientInfo struct {
+	IP          string
+	UserAgent   string
+	SessionName string
+	// CertThumbprint identifies the certificate the client presented over
+	// mutual TLS, empty if it did not present one.
+	CertThumbprint string
+}
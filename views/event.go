@@ -0,0 +1,11 @@
+package views
+
+type SecurityEventResponse struct {
+	ID        int64    `json:"id"`
+	Type      string   `json:"type"`
+	UserGUID  string   `json:"user_guid,omitempty"`
+	SessionID string   `json:"session_id,omitempty"`
+	JTIs      []string `json:"jti,omitempty"`
+	KeyID     string   `json:"kid,omitempty"`
+	Time      int64    `json:"time"`
+}
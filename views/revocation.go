@@ -0,0 +1,15 @@
+package views
+
+type RevokedTokenResponse struct {
+	JTI       string `json:"jti"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// RevocationFeedResponse lists revoked access tokens that have not expired
+// yet. Full is set when Revoked is a complete snapshot rather than the changes
+// since the requested cursor.
+type RevocationFeedResponse struct {
+	Cursor  int64                  `json:"cursor"`
+	Full    bool                   `json:"full"`
+	Revoked []RevokedTokenResponse `json:"revoked"`
+}
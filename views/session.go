@@ -0,0 +1,14 @@
+package views
+
+import "time"
+
+type SessionResponse struct {
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	UserAgent       string     `json:"user_agent"`
+	ClientIP        string     `json:"client_ip"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastRefreshedAt *time.Time `json:"last_refreshed_at,omitempty"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	Current         bool       `json:"current"`
+}
@@ -0,0 +1,26 @@
+package views
+
+// VerifyRequest lists the roles and scopes a verified token must all carry.
+// CertThumbprint identifies the certificate presented by the client using
+// the token, which must match for certificate-bound tokens.
+type VerifyRequest struct {
+	Roles          []string
+	Scopes         []string
+	CertThumbprint string
+}
+
+type VerifyResponse struct {
+	UserGUID  string   `json:"user_id"`
+	Roles     []string `json:"roles,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	AMR       []string `json:"amr,omitempty"`
+	ExpiresIn int      `json:"expires_in"`
+	// Cnf is set for tokens bound to a client certificate.
+	Cnf *Confirmation `json:"cnf,omitempty"`
+}
+
+// Confirmation holds the thumbprint of the certificate a token is bound to,
+// as in RFC 8705.
+type Confirmation struct {
+	CertThumbprint string `json:"x5t#S256"`
+}
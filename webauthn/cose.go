@@ -0,0 +1,141 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+)
+
+// COSE algorithm identifiers accepted for credentials.
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+// COSE key parameters, see RFC 8152 section 7 and 13.
+const (
+	coseKty = 1
+	coseAlg = 3
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1
+	coseE   = -2
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+var ErrUnsupportedKey = errors.New("webauthn: unsupported public key")
+
+// publicKey is a credential public key decoded from its COSE_Key form.
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+func parsePublicKey(coseKey []byte) (publicKey, error) {
+	var pk publicKey
+
+	item, rest, err := decodeCBOR(coseKey)
+	if err != nil {
+		return pk, err
+	}
+
+	if len(rest) != 0 {
+		return pk, errCBOR
+	}
+
+	m, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return pk, errCBOR
+	}
+
+	kty, _ := m[int64(coseKty)].(int64)
+	pk.alg, _ = m[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == ktyEC2 && pk.alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return pk, ErrUnsupportedKey
+		}
+
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return pk, ErrUnsupportedKey
+		}
+
+		pk.key = key
+	case kty == ktyOKP && pk.alg == AlgEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return pk, ErrUnsupportedKey
+		}
+
+		pk.key = ed25519.PublicKey(x)
+	case kty == ktyRSA && pk.alg == AlgRS256:
+		n, _ := m[int64(coseN)].([]byte)
+		e, _ := m[int64(coseE)].([]byte)
+
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return pk, ErrUnsupportedKey
+		}
+
+		pk.key = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	default:
+		return pk, ErrUnsupportedKey
+	}
+
+	return pk, nil
+}
+
+func (pk publicKey) verify(data, sig []byte) bool {
+	switch key := pk.key.(type) {
+	case *ecdsa.PublicKey:
+		var esig struct {
+			R, S *big.Int
+		}
+
+		rest, err := asn1.Unmarshal(sig, &esig)
+		if err != nil || len(rest) != 0 {
+			return false
+		}
+
+		digest := sha256.Sum256(data)
+
+		return ecdsa.Verify(key, digest[:], esig.R, esig.S)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	default:
+		return false
+	}
+}
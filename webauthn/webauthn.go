@@ -0,0 +1,238 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+// Authenticator data flags, see WebAuthn Level 2 section 6.1.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+
+	challengeSize = 32
+)
+
+var (
+	ErrInvalidClientData  = errors.New("webauthn: client data does not match the ceremony")
+	ErrInvalidAuthData    = errors.New("webauthn: authenticator data is invalid")
+	ErrInvalidAttestation = errors.New("webauthn: attestation is invalid or unsupported")
+	ErrInvalidSignature   = errors.New("webauthn: signature is invalid")
+)
+
+// RelyingParty verifies ceremonies for a single relying party.
+type RelyingParty struct {
+	ID     string
+	Name   string
+	Origin string
+}
+
+// Credential is a public key credential created by a registration ceremony.
+type Credential struct {
+	ID           []byte
+	PublicKey    []byte // COSE_Key
+	SignCount    uint32
+	UserVerified bool
+}
+
+// Assertion is the result of a verified authentication ceremony.
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// NewChallenge returns a random challenge for a new ceremony.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+// VerifyRegistration checks the response of navigator.credentials.create for
+// challenge and returns the new credential. Only the "none" and self "packed"
+// attestation formats are accepted since passkeys rarely carry anything else.
+func (rp *RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	item, rest, err := decodeCBOR(attestationObject)
+	if err != nil || len(rest) != 0 {
+		return nil, ErrInvalidAttestation
+	}
+
+	attestation, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidAttestation
+	}
+
+	format, _ := attestation["fmt"].(string)
+	rawAuthData, _ := attestation["authData"].([]byte)
+	statement, _ := attestation["attStmt"].(map[interface{}]interface{})
+
+	data, err := rp.parseAuthData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	if data.flags&flagAttestedData == 0 {
+		return nil, ErrInvalidAuthData
+	}
+
+	key, err := parsePublicKey(data.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "none":
+		if len(statement) != 0 {
+			return nil, ErrInvalidAttestation
+		}
+	case "packed":
+		alg, _ := statement["alg"].(int64)
+		sig, _ := statement["sig"].([]byte)
+
+		if _, ok := statement["x5c"]; ok || alg != key.alg {
+			return nil, ErrInvalidAttestation
+		}
+
+		if !key.verify(signedData(rawAuthData, clientDataJSON), sig) {
+			return nil, ErrInvalidAttestation
+		}
+	default:
+		return nil, ErrInvalidAttestation
+	}
+
+	return &Credential{
+		ID:           data.credentialID,
+		PublicKey:    data.publicKey,
+		SignCount:    data.signCount,
+		UserVerified: data.flags&flagUserVerified != 0,
+	}, nil
+}
+
+// VerifyAssertion checks the response of navigator.credentials.get for
+// challenge against the stored COSE publicKey of the credential.
+func (rp *RelyingParty) VerifyAssertion(challenge, publicKey, clientDataJSON, authenticatorData, signature []byte) (*Assertion, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+
+	data, err := rp.parseAuthData(authenticatorData)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !key.verify(signedData(authenticatorData, clientDataJSON), signature) {
+		return nil, ErrInvalidSignature
+	}
+
+	return &Assertion{
+		SignCount:    data.signCount,
+		UserVerified: data.flags&flagUserVerified != 0,
+	}, nil
+}
+
+func (rp *RelyingParty) verifyClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return ErrInvalidClientData
+	}
+
+	received, err := base64.RawURLEncoding.DecodeString(data.Challenge)
+	if err != nil {
+		return ErrInvalidClientData
+	}
+
+	if data.Type != ceremony || data.Origin != rp.Origin || subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return ErrInvalidClientData
+	}
+
+	return nil
+}
+
+func (rp *RelyingParty) parseAuthData(raw []byte) (authData, error) {
+	var data authData
+
+	if len(raw) < 37 {
+		return data, ErrInvalidAuthData
+	}
+
+	data.rpIDHash = raw[:32]
+	data.flags = raw[32]
+	data.signCount = binary.BigEndian.Uint32(raw[33:37])
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(data.rpIDHash, rpIDHash[:]) {
+		return data, ErrInvalidAuthData
+	}
+
+	if data.flags&flagUserPresent == 0 {
+		return data, ErrInvalidAuthData
+	}
+
+	if data.flags&flagAttestedData == 0 {
+		return data, nil
+	}
+
+	// aaguid (16) followed by the length prefixed credential ID and its key
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return data, ErrInvalidAuthData
+	}
+
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+
+	if len(rest) < idLen {
+		return data, ErrInvalidAuthData
+	}
+
+	data.credentialID = append([]byte(nil), rest[:idLen]...)
+	rest = rest[idLen:]
+
+	_, extensions, err := decodeCBOR(rest)
+	if err != nil {
+		return data, ErrInvalidAuthData
+	}
+
+	data.publicKey = append([]byte(nil), rest[:len(rest)-len(extensions)]...)
+
+	return data, nil
+}
+
+func signedData(authenticatorData, clientDataJSON []byte) []byte {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+
+	return append(append([]byte(nil), authenticatorData...), clientDataHash[:]...)
+}
@@ -0,0 +1,51 @@
+package webauthn_test
+
+import (
+	"testing"
+
+	"github.com/flaambe/authservice/webauthn"
+	"github.com/flaambe/authservice/webauthn/webauthntest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	rp := &webauthn.RelyingParty{ID: "example.com", Name: "Example", Origin: "https://example.com"}
+
+	authenticator, err := webauthntest.NewAuthenticator()
+	require.NoError(t, err)
+
+	challenge, err := webauthn.NewChallenge()
+	require.NoError(t, err)
+
+	attestation := authenticator.Create(rp.ID, rp.Origin, challenge)
+
+	credential, err := rp.VerifyRegistration(challenge, attestation.ClientDataJSON, attestation.AttestationObject)
+	require.NoError(t, err)
+	require.Equal(t, authenticator.CredentialID, credential.ID)
+
+	// Challenge of another ceremony is rejected
+	otherChallenge, err := webauthn.NewChallenge()
+	require.NoError(t, err)
+
+	_, err = rp.VerifyRegistration(otherChallenge, attestation.ClientDataJSON, attestation.AttestationObject)
+	require.Equal(t, webauthn.ErrInvalidClientData, err)
+
+	assertion := authenticator.Get(rp.ID, rp.Origin, challenge)
+
+	result, err := rp.VerifyAssertion(challenge, credential.PublicKey, assertion.ClientDataJSON, assertion.AuthenticatorData, assertion.Signature)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), result.SignCount)
+	require.True(t, result.UserVerified)
+
+	// Tampered authenticator data fails signature verification
+	assertion.AuthenticatorData[36]++
+
+	_, err = rp.VerifyAssertion(challenge, credential.PublicKey, assertion.ClientDataJSON, assertion.AuthenticatorData, assertion.Signature)
+	require.Equal(t, webauthn.ErrInvalidSignature, err)
+
+	// Assertion for another relying party is rejected
+	assertion = authenticator.Get("evil.example", rp.Origin, challenge)
+
+	_, err = rp.VerifyAssertion(challenge, credential.PublicKey, assertion.ClientDataJSON, assertion.AuthenticatorData, assertion.Signature)
+	require.Equal(t, webauthn.ErrInvalidAuthData, err)
+}
@@ -0,0 +1,185 @@
+// Package webauthntest provides a software authenticator that produces
+// WebAuthn attestation and assertion responses for tests.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+)
+
+// Authenticator holds a single ES256 credential.
+type Authenticator struct {
+	CredentialID []byte
+	SignCount    uint32
+
+	key *ecdsa.PrivateKey
+}
+
+// Attestation is the response of a registration ceremony.
+type Attestation struct {
+	ClientDataJSON    []byte
+	AttestationObject []byte
+}
+
+// AssertionResponse is the response of an authentication ceremony.
+type AssertionResponse struct {
+	ClientDataJSON    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+}
+
+func NewAuthenticator() (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &Authenticator{CredentialID: id, key: key}, nil
+}
+
+// Create answers a registration ceremony with "none" attestation.
+func (a *Authenticator) Create(rpID, origin string, challenge []byte) Attestation {
+	clientDataJSON := clientData("webauthn.create", origin, challenge)
+
+	var attested []byte
+	attested = append(attested, make([]byte, 16)...) // aaguid
+	attested = appendUint16(attested, uint16(len(a.CredentialID)))
+	attested = append(attested, a.CredentialID...)
+	attested = append(attested, a.coseKey()...)
+
+	authData := a.authData(rpID, 0x41, attested)
+
+	var attestationObject []byte
+	attestationObject = appendHeader(attestationObject, 5, 3)
+	attestationObject = appendText(attestationObject, "fmt")
+	attestationObject = appendText(attestationObject, "none")
+	attestationObject = appendText(attestationObject, "attStmt")
+	attestationObject = appendHeader(attestationObject, 5, 0)
+	attestationObject = appendText(attestationObject, "authData")
+	attestationObject = appendBytes(attestationObject, authData)
+
+	return Attestation{ClientDataJSON: clientDataJSON, AttestationObject: attestationObject}
+}
+
+// Get answers an authentication ceremony, incrementing the sign counter.
+func (a *Authenticator) Get(rpID, origin string, challenge []byte) AssertionResponse {
+	a.SignCount++
+
+	clientDataJSON := clientData("webauthn.get", origin, challenge)
+	authData := a.authData(rpID, 0x05, nil)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+
+	r, s, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+	if err != nil {
+		panic(err)
+	}
+
+	signature, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		panic(err)
+	}
+
+	return AssertionResponse{
+		ClientDataJSON:    clientDataJSON,
+		AuthenticatorData: authData,
+		Signature:         signature,
+	}
+}
+
+func (a *Authenticator) authData(rpID string, flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+
+	data := append([]byte(nil), rpIDHash[:]...)
+	data = append(data, flags)
+	data = appendUint32(data, a.SignCount)
+
+	return append(data, attested...)
+}
+
+func (a *Authenticator) coseKey() []byte {
+	x := a.key.X.FillBytes(make([]byte, 32))
+	y := a.key.Y.FillBytes(make([]byte, 32))
+
+	var key []byte
+	key = appendHeader(key, 5, 5)
+	key = appendInt(key, 1)
+	key = appendInt(key, 2) // kty: EC2
+	key = appendInt(key, 3)
+	key = appendInt(key, -7) // alg: ES256
+	key = appendInt(key, -1)
+	key = appendInt(key, 1) // crv: P-256
+	key = appendInt(key, -2)
+	key = appendBytes(key, x)
+	key = appendInt(key, -3)
+	key = appendBytes(key, y)
+
+	return key
+}
+
+func clientData(ceremony, origin string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    origin,
+	})
+
+	return data
+}
+
+// minimal CBOR encoding
+func appendHeader(b []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(b, major<<5|byte(n))
+	case n <= 0xff:
+		return append(b, major<<5|24, byte(n))
+	case n <= 0xffff:
+		return appendUint16(append(b, major<<5|25), uint16(n))
+	default:
+		return appendUint32(append(b, major<<5|26), uint32(n))
+	}
+}
+
+func appendInt(b []byte, n int64) []byte {
+	if n < 0 {
+		return appendHeader(b, 1, uint64(-1-n))
+	}
+
+	return appendHeader(b, 0, uint64(n))
+}
+
+func appendBytes(b, v []byte) []byte {
+	return append(appendHeader(b, 2, uint64(len(v))), v...)
+}
+
+func appendText(b []byte, v string) []byte {
+	return append(appendHeader(b, 3, uint64(len(v))), v...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], v)
+
+	return append(b, buf[:]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+
+	return append(b, buf[:]...)
+}